@@ -6,16 +6,15 @@ require (
 	github.com/RoaringBitmap/roaring v1.9.4
 	github.com/araddon/gou v0.0.0-20211019181548-e7d08105776c
 	github.com/araddon/qlbridge v0.0.2
+	github.com/blevesearch/mmap-go v1.0.4
 	github.com/blevesearch/vellum v1.0.10
 	github.com/bmizerany/assert v0.0.0-20160611221934-b7ed37b82869
-	github.com/spf13/cast v1.6.0
 	github.com/tidwall/btree v1.7.0
 )
 
 require (
 	github.com/araddon/dateparse v0.0.0-20190622164848-0fb0a474d195 // indirect
 	github.com/bits-and-blooms/bitset v1.12.0 // indirect
-	github.com/blevesearch/mmap-go v1.0.4 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/lytics/datemath v0.0.0-20180727225141-3ada1c10b5de // indirect
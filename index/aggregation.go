@@ -0,0 +1,612 @@
+package index
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// 聚合：Index.Aggregate 对查询匹配的文档进行聚合，聚合直接在索引上计算，不需要读取文档：
+//   - 字符串字段按词典中的每个词项与结果取交集计数，文本字段按词元计数
+//   - 数值、时间字段遍历范围索引（btree）中的每个值计数，时间字段的值为 time.Time
+//   - bool 字段按 true、false 计数
+//   - 数值统计（StatsAgg）、百分位数（PercentilesAgg）、固定间隔直方图（HistogramAgg）以及指定区间的直方图（RangeAgg）
+//     遍历数值、时间字段的范围索引，每个值的文档数由其倒排表与结果取交集得到。最小值、最大值、百分位数以及直方图的
+//     键与字段的类型一致（int64、uint64、float64，时间字段为 time.Time），整数不经浮点数转换，超过 2^53 的值不会丢失精度
+//
+// 多值字段（如 []string）的文档计入其每个值的桶。桶可以包含子聚合，子聚合在桶内的文档上计算：
+//
+//	aggs, err := idx.Aggregate(`Age >= 18`, index.TermsAgg{Field: "City", Size: 5,
+//		Aggs: []index.Agg{index.TermsAgg{Field: "Gender"}}})
+//	for _, bucket := range aggs["City"].Buckets {
+//		fmt.Println(bucket.Key, bucket.Count, bucket.Aggs["Gender"].Buckets)
+//	}
+
+// DefaultAggSize is the number of buckets of a terms aggregation without Size
+const DefaultAggSize = 10
+
+// Agg is an aggregation of the docs matched by a query, see TermsAgg, StatsAgg, PercentilesAgg, HistogramAgg and
+// RangeAgg
+type Agg interface {
+	// aggName is the name of the results of the aggregation
+	aggName() string
+	// aggregate computes the aggregation over the docs, live internal doc ids of the snapshot
+	aggregate(ac *aggContext, docs *roaring.Bitmap) (*AggResult, error)
+}
+
+// Aggregations are the results of aggregations, by name
+type Aggregations map[string]*AggResult
+
+// AggResult is the result of an aggregation
+type AggResult struct {
+	Buckets     []*Bucket    `json:"buckets,omitempty"`
+	Other       uint64       `json:"other,omitempty"` // values counted by the buckets beyond the Size first
+	Stats       *Stats       `json:"stats,omitempty"`
+	Percentiles []Percentile `json:"percentiles,omitempty"`
+}
+
+// Bucket is a value of a field along with the number of docs holding it
+type Bucket struct {
+	Key   interface{}  `json:"key"` // string, int64, uint64, float64, bool or time.Time, the lower bound or the key of a range for histograms
+	Count uint64       `json:"count"`
+	Aggs  Aggregations `json:"aggs,omitempty"` // sub aggregations over the docs of the bucket
+}
+
+// TermsAgg counts the docs of the most frequent values of a field, by descending count then ascending value
+type TermsAgg struct {
+	Name  string // name of the results, the field by default
+	Field string
+	Size  int   // number of buckets, DefaultAggSize if 0, all if negative
+	Aggs  []Agg // sub aggregations computed for each bucket
+}
+
+// Aggregate 对满足查询条件的文档进行聚合，返回以聚合名称为键的结果
+func (i *Index) Aggregate(query string, aggs ...Agg) (Aggregations, error) {
+	pq, err := i.parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := i.snap.Load()
+	res, err := snap.match(pq, nil)
+	if err != nil {
+		return nil, err
+	}
+	return (&aggContext{snap: snap, mapping: i.mapping}).aggregate(aggs, res.internalDocIds)
+}
+
+// aggContext carries what the aggregations are computed from
+type aggContext struct {
+	snap    *snapshot
+	mapping *Mapping
+}
+
+func (ac *aggContext) aggregate(aggs []Agg, docs *roaring.Bitmap) (Aggregations, error) {
+	results := make(Aggregations, len(aggs))
+	for _, agg := range aggs {
+		name := agg.aggName()
+		if _, ok := results[name]; ok {
+			return nil, fmt.Errorf("aggregation:%s is defined twice", name)
+		}
+		res, err := agg.aggregate(ac, docs)
+		if err != nil {
+			return nil, fmt.Errorf("aggregation:%s: %v", name, err)
+		}
+		results[name] = res
+	}
+	return results, nil
+}
+
+// field returns the mapping of the field an aggregation is computed on
+func (ac *aggContext) field(field string) (*FieldMapping, error) {
+	fm, ok := ac.mapping.Field(field)
+	if !ok {
+		return nil, fmt.Errorf("field:`%s` is not indexed", field)
+	}
+	return fm, nil
+}
+
+func (agg TermsAgg) aggName() string {
+	return cmp.Or(agg.Name, agg.Field)
+}
+
+func (agg TermsAgg) aggregate(ac *aggContext, docs *roaring.Bitmap) (*AggResult, error) {
+	fm, err := ac.field(agg.Field)
+	if err != nil {
+		return nil, err
+	}
+
+	// counts and postings of the values, over all the segments
+	counts := make(map[interface{}]uint64)
+	postings := make(map[interface{}][]*roaring.Bitmap)
+	add := func(key interface{}, p *roaring.Bitmap, segDocs *roaring.Bitmap) {
+		if n := p.AndCardinality(segDocs); n > 0 {
+			counts[key] += n
+			postings[key] = append(postings[key], p)
+		}
+	}
+	for _, seg := range ac.snap.segments {
+		fieldID, ok := seg.fieldToFieldId[agg.Field]
+		if !ok {
+			continue
+		}
+		segDocs := roaring.And(docs, seg.fullDocIDBits)
+		if segDocs.IsEmpty() {
+			continue
+		}
+
+		if termDic, ok := seg.fieldsTermDic[fieldID]; ok {
+			for term, termID := range termDic.termToTermID {
+				add(term, seg.postings[termID].Postings(), segDocs)
+			}
+		}
+		if fieldPostings, ok := seg.rangePostings[fieldID]; ok {
+			fieldPostings.rangePosting.Scan(func(item Item) bool {
+				add(aggKey(fm.kind(), item.numeric), item.postings, segDocs)
+				return true
+			})
+		}
+		if fieldPostings, ok := seg.boolPostings[fieldID]; ok {
+			add(false, fieldPostings.Postings(false), segDocs)
+			add(true, fieldPostings.Postings(true), segDocs)
+		}
+	}
+
+	res := &AggResult{Buckets: make([]*Bucket, 0, len(counts))}
+	for key, count := range counts {
+		res.Buckets = append(res.Buckets, &Bucket{Key: key, Count: count})
+	}
+	sort.Slice(res.Buckets, func(i, j int) bool {
+		a, b := res.Buckets[i], res.Buckets[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return compareAggKeys(a.Key, b.Key) < 0
+	})
+
+	size := agg.Size
+	if size == 0 {
+		size = DefaultAggSize
+	}
+	if size > 0 && len(res.Buckets) > size {
+		for _, bucket := range res.Buckets[size:] {
+			res.Other += bucket.Count
+		}
+		res.Buckets = res.Buckets[:size]
+	}
+
+	bucketPostings := make([][]*roaring.Bitmap, len(res.Buckets))
+	for j, bucket := range res.Buckets {
+		bucketPostings[j] = postings[bucket.Key]
+	}
+	return res, ac.subAggregate(res.Buckets, bucketPostings, docs, agg.Aggs)
+}
+
+// compareAggKeys orders the keys of the buckets of a field, numbers of different kinds compare as floats
+func compareAggKeys(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b)
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0
+			case b:
+				return -1
+			}
+			return 1
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b)
+		}
+	case int64:
+		if b, ok := b.(int64); ok {
+			return cmp.Compare(a, b)
+		}
+	case uint64:
+		if b, ok := b.(uint64); ok {
+			return cmp.Compare(a, b)
+		}
+	}
+	return cmp.Compare(aggKeyFloat(a), aggKeyFloat(b))
+}
+
+func aggKeyFloat(key interface{}) float64 {
+	switch key := key.(type) {
+	case int64:
+		return float64(key)
+	case uint64:
+		return float64(key)
+	case float64:
+		return key
+	}
+	return 0
+}
+
+// aggKey is the key of a value of the range postings of a field, the nanoseconds of a time field are converted back
+// to a time.Time
+func aggKey(kind fieldKind, num interface{}) interface{} {
+	if n, ok := num.(int64); ok && kind == kindTime {
+		return time.Unix(0, n).UTC()
+	}
+	return num
+}
+
+// numericValues calls fn with the values of a number or time field held by the docs, in ascending order for each
+// segment, along with the number of docs holding the value and the postings of the value. The values are those of
+// the range postings: int64, uint64 or float64, the nanoseconds of a time field, and the kind of the field is
+// returned so they can be converted back, see aggKey.
+func (ac *aggContext) numericValues(field string, docs *roaring.Bitmap, fn func(val interface{}, count uint64, postings *roaring.Bitmap)) (fieldKind, error) {
+	fm, err := ac.field(field)
+	if err != nil {
+		return kindUnknown, err
+	}
+	kind := fm.kind()
+	if kind != kindNumber && kind != kindTime && kind != kindUnknown {
+		return kind, fmt.Errorf("field:`%s` is a %s field, only number and time fields are supported", field, kind)
+	}
+
+	for _, seg := range ac.snap.segments {
+		fieldID, ok := seg.fieldToFieldId[field]
+		if !ok {
+			continue
+		}
+		fieldPostings, ok := seg.rangePostings[fieldID]
+		if !ok {
+			continue
+		}
+		segDocs := roaring.And(docs, seg.fullDocIDBits)
+		if segDocs.IsEmpty() {
+			continue
+		}
+
+		fieldPostings.rangePosting.Scan(func(item Item) bool {
+			if n := item.postings.AndCardinality(segDocs); n > 0 {
+				fn(item.numeric, n, item.postings)
+			}
+			return true
+		})
+	}
+	return kind, nil
+}
+
+// Stats are statistics of the values of a field, the values of a multi-valued field count one by one. Min and Max
+// are of the type of the values: int64, uint64, float64, or time.Time for a time field, nil without values.
+type Stats struct {
+	Count uint64      `json:"count"`
+	Min   interface{} `json:"min"`
+	Max   interface{} `json:"max"`
+	Sum   float64     `json:"sum"` // integers are summed exactly and rounded once, 0 for a time field
+	Avg   interface{} `json:"avg"` // float64, or time.Time for a time field
+}
+
+// StatsAgg computes the count, min, max, sum and average of the values of a number or time field
+type StatsAgg struct {
+	Name  string // name of the results, the field by default
+	Field string
+}
+
+func (agg StatsAgg) aggName() string {
+	return cmp.Or(agg.Name, agg.Field)
+}
+
+func (agg StatsAgg) aggregate(ac *aggContext, docs *roaring.Bitmap) (*AggResult, error) {
+	stats := &Stats{}
+	var minVal, maxVal interface{}
+	var floatSum float64
+	intSum, term := new(big.Int), new(big.Int)
+	kind, err := ac.numericValues(agg.Field, docs, func(val interface{}, count uint64, _ *roaring.Bitmap) {
+		stats.Count += count
+		switch val := val.(type) {
+		case int64:
+			intSum.Add(intSum, term.Mul(term.SetInt64(val), new(big.Int).SetUint64(count)))
+		case uint64:
+			intSum.Add(intSum, term.Mul(term.SetUint64(val), new(big.Int).SetUint64(count)))
+		case float64:
+			floatSum += val * float64(count)
+		}
+		if minVal == nil || compareAggKeys(val, minVal) < 0 {
+			minVal = val
+		}
+		if maxVal == nil || compareAggKeys(val, maxVal) > 0 {
+			maxVal = val
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stats.Count == 0 {
+		return &AggResult{Stats: stats}, nil
+	}
+
+	stats.Min, stats.Max = aggKey(kind, minVal), aggKey(kind, maxVal)
+	if kind == kindTime {
+		avg := intSum.Quo(intSum, new(big.Int).SetUint64(stats.Count)) // between the min and the max, an int64
+		stats.Avg = time.Unix(0, avg.Int64()).UTC()
+		return &AggResult{Stats: stats}, nil
+	}
+
+	sum := new(big.Float).SetInt(intSum)
+	sum.Add(sum, big.NewFloat(floatSum))
+	stats.Sum, _ = sum.Float64()
+	avg, _ := sum.Quo(sum, new(big.Float).SetUint64(stats.Count)).Float64()
+	stats.Avg = avg
+	return &AggResult{Stats: stats}, nil
+}
+
+// DefaultPercents are the percents of a PercentilesAgg without Percents
+var DefaultPercents = []float64{1, 5, 25, 50, 75, 95, 99}
+
+// Percentile is the value of a field below which a percentage of the values fall. The value is of the type of the
+// values of the field: int64, uint64, float64, or time.Time for a time field.
+type Percentile struct {
+	Percent float64     `json:"percent"`
+	Value   interface{} `json:"value"`
+}
+
+// PercentilesAgg computes exact percentiles of the values of a number or time field, interpolating linearly between
+// the two closest values. The interpolation of integers and times is rounded to the nearest integer.
+type PercentilesAgg struct {
+	Name     string // name of the results, the field by default
+	Field    string
+	Percents []float64 // between 0 and 100, DefaultPercents if empty
+}
+
+func (agg PercentilesAgg) aggName() string {
+	return cmp.Or(agg.Name, agg.Field)
+}
+
+func (agg PercentilesAgg) aggregate(ac *aggContext, docs *roaring.Bitmap) (*AggResult, error) {
+	percents := agg.Percents
+	if len(percents) == 0 {
+		percents = DefaultPercents
+	}
+	for _, p := range percents {
+		if p < 0 || p > 100 || math.IsNaN(p) {
+			return nil, fmt.Errorf("percent %v is not between 0 and 100", p)
+		}
+	}
+
+	// the distinct values and their counts, over all the segments
+	counts := make(map[interface{}]uint64)
+	var total uint64
+	kind, err := ac.numericValues(agg.Field, docs, func(val interface{}, count uint64, _ *roaring.Bitmap) {
+		counts[val] += count
+		total += count
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &AggResult{Percentiles: make([]Percentile, 0, len(percents))}
+	if total == 0 {
+		return res, nil
+	}
+
+	values := make([]interface{}, 0, len(counts))
+	for val := range counts {
+		values = append(values, val)
+	}
+	sort.Slice(values, func(i, j int) bool { return compareAggKeys(values[i], values[j]) < 0 })
+	// valueAt returns the value of the rank-th value, from 0, in ascending order
+	valueAt := func(rank uint64) interface{} {
+		for _, val := range values {
+			if rank < counts[val] {
+				return val
+			}
+			rank -= counts[val]
+		}
+		return values[len(values)-1]
+	}
+
+	for _, p := range percents {
+		rank := p / 100 * float64(total-1)
+		lo := valueAt(uint64(rank))
+		hi := valueAt(uint64(math.Ceil(rank)))
+		res.Percentiles = append(res.Percentiles, Percentile{p, aggKey(kind, interpolate(lo, hi, rank-math.Floor(rank)))})
+	}
+	return res, nil
+}
+
+// interpolate returns lo + (hi-lo)*frac, hi >= lo, in the type of the values. The difference of integers is
+// computed exactly and the result rounded to the nearest integer.
+func interpolate(lo, hi interface{}, frac float64) interface{} {
+	// step is (hi-lo)*frac rounded, never beyond diff, the exact difference
+	step := func(diff uint64) uint64 {
+		return min(uint64(math.Round(float64(diff)*frac)), diff)
+	}
+	switch lo := lo.(type) {
+	case int64:
+		if hi, ok := hi.(int64); ok {
+			return int64(uint64(lo) + step(uint64(hi)-uint64(lo))) // the difference of int64 fits an uint64
+		}
+	case uint64:
+		if hi, ok := hi.(uint64); ok {
+			return lo + step(hi-lo)
+		}
+	}
+	l, h := aggKeyFloat(lo), aggKeyFloat(hi)
+	return l + (h-l)*frac
+}
+
+// HistogramAgg counts the docs by fixed interval of the values of a number or time field, the key of a bucket is the
+// lower bound of its interval: a value v falls in the bucket floor(v/Interval)*Interval. Empty buckets are omitted.
+// The keys are of the type of the values of the field. The interval of an integer field must be an integer, the one
+// of a time field is in nanoseconds, such as float64(24*time.Hour), and its keys are time.Time.
+type HistogramAgg struct {
+	Name     string // name of the results, the field by default
+	Field    string
+	Interval float64
+	Aggs     []Agg // sub aggregations computed for each bucket
+}
+
+func (agg HistogramAgg) aggName() string {
+	return cmp.Or(agg.Name, agg.Field)
+}
+
+func (agg HistogramAgg) aggregate(ac *aggContext, docs *roaring.Bitmap) (*AggResult, error) {
+	if !(agg.Interval > 0) || math.IsInf(agg.Interval, 0) {
+		return nil, fmt.Errorf("histogram interval must be a positive number, got %v", agg.Interval)
+	}
+
+	var keyErr error
+	counts := make(map[interface{}]uint64)
+	postings := make(map[interface{}][]*roaring.Bitmap)
+	kind, err := ac.numericValues(agg.Field, docs, func(val interface{}, count uint64, p *roaring.Bitmap) {
+		key, err := histogramKey(val, agg.Interval)
+		if err != nil {
+			keyErr = err
+			return
+		}
+		counts[key] += count
+		postings[key] = append(postings[key], p)
+	})
+	if err = cmp.Or(err, keyErr); err != nil {
+		return nil, err
+	}
+
+	keys := make([]interface{}, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return compareAggKeys(keys[i], keys[j]) < 0 })
+	res := &AggResult{Buckets: make([]*Bucket, len(keys))}
+	bucketPostings := make([][]*roaring.Bitmap, len(keys))
+	for j, key := range keys {
+		res.Buckets[j] = &Bucket{Key: aggKey(kind, key), Count: counts[key]}
+		bucketPostings[j] = postings[key]
+	}
+	return res, ac.subAggregate(res.Buckets, bucketPostings, docs, agg.Aggs)
+}
+
+// histogramKey returns the lower bound of the interval of the value, in the type of the value. Integers are computed
+// exactly, the lower bound of the lowest int64 values is clamped to math.MinInt64 when it overflows.
+func histogramKey(val interface{}, interval float64) (interface{}, error) {
+	switch val := val.(type) {
+	case float64:
+		return math.Floor(val/interval) * interval, nil
+	case int64:
+		if interval != math.Trunc(interval) || interval >= 1<<63 {
+			return nil, fmt.Errorf("histogram interval of an integer field must be an integer below 2^63, got %v", interval)
+		}
+		iv := int64(interval)
+		rem := val % iv
+		if rem < 0 {
+			rem += iv
+		}
+		if val < math.MinInt64+rem {
+			return int64(math.MinInt64), nil
+		}
+		return val - rem, nil
+	case uint64:
+		if interval != math.Trunc(interval) || interval >= 1<<64 {
+			return nil, fmt.Errorf("histogram interval of an integer field must be an integer below 2^64, got %v", interval)
+		}
+		return val - val%uint64(interval), nil
+	}
+	return nil, fmt.Errorf("unexpected value %v", val)
+}
+
+// AggRange is a range of a RangeAgg, From is inclusive and To exclusive
+type AggRange struct {
+	Key      string // key of the bucket, "From-To" by default
+	From, To float64
+}
+
+// key returns the key of the bucket of the range, infinite bounds are left out
+func (r AggRange) key() string {
+	if r.Key != "" {
+		return r.Key
+	}
+	var from, to string
+	if !math.IsInf(r.From, -1) {
+		from = strconv.FormatFloat(r.From, 'g', -1, 64)
+	}
+	if !math.IsInf(r.To, 1) {
+		to = strconv.FormatFloat(r.To, 'g', -1, 64)
+	}
+	return from + "-" + to
+}
+
+// RangeAgg counts the docs of each range of the values of a number or time field, the buckets are in the order of
+// the ranges, ranges may overlap. Use math.Inf for unbounded ranges.
+type RangeAgg struct {
+	Name   string // name of the results, the field by default
+	Field  string
+	Ranges []AggRange
+	Aggs   []Agg // sub aggregations computed for each bucket
+}
+
+func (agg RangeAgg) aggName() string {
+	return cmp.Or(agg.Name, agg.Field)
+}
+
+func (agg RangeAgg) aggregate(ac *aggContext, docs *roaring.Bitmap) (*AggResult, error) {
+	if len(agg.Ranges) == 0 {
+		return nil, fmt.Errorf("range aggregation needs at least one range")
+	}
+	for _, r := range agg.Ranges {
+		if !(r.From < r.To) {
+			return nil, fmt.Errorf("range %s is empty", r.key())
+		}
+	}
+
+	counts := make([]uint64, len(agg.Ranges))
+	postings := make([][]*roaring.Bitmap, len(agg.Ranges))
+	_, err := ac.numericValues(agg.Field, docs, func(val interface{}, count uint64, p *roaring.Bitmap) {
+		num := aggBigFloat(val)
+		for j, r := range agg.Ranges {
+			if num.Cmp(big.NewFloat(r.From)) >= 0 && num.Cmp(big.NewFloat(r.To)) < 0 {
+				counts[j] += count
+				postings[j] = append(postings[j], p)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &AggResult{Buckets: make([]*Bucket, len(agg.Ranges))}
+	for j, r := range agg.Ranges {
+		res.Buckets[j] = &Bucket{Key: r.key(), Count: counts[j]}
+	}
+	return res, ac.subAggregate(res.Buckets, postings, docs, agg.Aggs)
+}
+
+// aggBigFloat returns the exact value of a value of the range postings, integers beyond 2^53 compare exactly to the
+// bounds of the ranges
+func aggBigFloat(val interface{}) *big.Float {
+	switch val := val.(type) {
+	case int64:
+		return new(big.Float).SetInt64(val)
+	case uint64:
+		return new(big.Float).SetUint64(val)
+	}
+	return big.NewFloat(aggKeyFloat(val))
+}
+
+// subAggregate computes the sub aggregations of the buckets over the docs of their postings
+func (ac *aggContext) subAggregate(buckets []*Bucket, postings [][]*roaring.Bitmap, docs *roaring.Bitmap, aggs []Agg) error {
+	if len(aggs) == 0 {
+		return nil
+	}
+	for j, bucket := range buckets {
+		bucketDocs := roaring.FastOr(postings[j]...)
+		bucketDocs.And(docs)
+		var err error
+		if bucket.Aggs, err = ac.aggregate(aggs, bucketDocs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package index_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+	"github.com/chirlchen/pans/index"
+)
+
+type Shop struct {
+	City   string    `index:"on"`
+	Tags   []string  `index:"on"`
+	Level  int       `index:"on"`
+	Vip    bool      `index:"on"`
+	Joined time.Time `index:"on"`
+	Desc   string    `index:"text"`
+}
+
+var (
+	jan = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	shops = []interface{}{
+		Shop{"深圳", []string{"food", "coffee"}, 1, true, jan, "fresh coffee"},
+		Shop{"深圳", []string{"food"}, 2, false, jan, "noodles"},
+		Shop{"广州", []string{"coffee"}, 1, true, feb, "coffee and cake"},
+		Shop{"广州", nil, 3, false, feb, "cake"},
+		Shop{"北京", []string{"food"}, 1, false, jan, "duck"},
+	}
+)
+
+func buildShops(t *testing.T) *index.Index {
+	i := buildIndex(t, []string{"1", "2", "3", "4", "5"}, shops, nil)
+	i.SetMergePolicy(index.MergePolicy{FlushSize: 2, MergeFactor: 8})
+	if err := i.Upsert("6", Shop{"深圳", []string{"tea"}, 2, true, feb, "tea"}); err != nil {
+		t.Fatalf("Index.Upsert() error = %v", err)
+	}
+	if err := i.Upsert("7", Shop{"上海", []string{"tea"}, 3, false, feb, "tea"}); err != nil {
+		t.Fatalf("Index.Upsert() error = %v", err)
+	}
+	if err := i.Delete("7"); err != nil {
+		t.Fatalf("Index.Delete() error = %v", err)
+	}
+	return i
+}
+
+func TestIndex_AggregateTerms(t *testing.T) {
+	i := buildShops(t)
+
+	type bucket struct {
+		Key   interface{}
+		Count uint64
+	}
+	buckets := func(res *index.AggResult) []bucket {
+		got := make([]bucket, 0, len(res.Buckets))
+		for _, b := range res.Buckets {
+			got = append(got, bucket{b.Key, b.Count})
+		}
+		return got
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		agg       index.TermsAgg
+		want      []bucket
+		wantOther uint64
+	}{
+		{name: "string", query: `Level >= 1`, agg: index.TermsAgg{Field: "City"},
+			want: []bucket{{"深圳", 3}, {"广州", 2}, {"北京", 1}}},
+		{name: "filtered", query: `Vip`, agg: index.TermsAgg{Field: "City"},
+			want: []bucket{{"深圳", 2}, {"广州", 1}}},
+		{name: "size", query: `Level >= 1`, agg: index.TermsAgg{Field: "City", Size: 1},
+			want: []bucket{{"深圳", 3}}, wantOther: 3},
+		{name: "multi-valued", query: `Level >= 1`, agg: index.TermsAgg{Name: "tags", Field: "Tags", Size: -1},
+			want: []bucket{{"food", 3}, {"coffee", 2}, {"tea", 1}}},
+		{name: "int", query: `City != "北京"`, agg: index.TermsAgg{Field: "Level"},
+			want: []bucket{{int64(1), 2}, {int64(2), 2}, {int64(3), 1}}},
+		{name: "bool", query: `Level >= 1`, agg: index.TermsAgg{Field: "Vip"},
+			want: []bucket{{false, 3}, {true, 3}}},
+		{name: "time", query: `Level >= 1`, agg: index.TermsAgg{Field: "Joined"},
+			want: []bucket{{jan, 3}, {feb, 3}}},
+		{name: "text", query: `Level == 1`, agg: index.TermsAgg{Field: "Desc", Size: 2},
+			want: []bucket{{"coffee", 2}, {"and", 1}}, wantOther: 3},
+		{name: "none", query: `City == "nope"`, agg: index.TermsAgg{Field: "City"}, want: []bucket{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aggs, err := i.Aggregate(tt.query, tt.agg)
+			if err != nil {
+				t.Fatalf("Index.Aggregate() error = %v", err)
+			}
+			name := tt.agg.Name
+			if name == "" {
+				name = tt.agg.Field
+			}
+			assert.Equalf(t, tt.want, buckets(aggs[name]), "Index.Aggregate() got: %v", buckets(aggs[name]))
+			assert.Equal(t, tt.wantOther, aggs[name].Other)
+		})
+	}
+}
+
+func TestIndex_AggregateNested(t *testing.T) {
+	i := buildShops(t)
+
+	aggs, err := i.Aggregate(`Level >= 1`,
+		index.TermsAgg{Field: "City", Aggs: []index.Agg{index.TermsAgg{Field: "Vip"}, index.TermsAgg{Field: "Tags"}}},
+		index.TermsAgg{Name: "levels", Field: "Level", Size: 1})
+	if err != nil {
+		t.Fatalf("Index.Aggregate() error = %v", err)
+	}
+
+	city := aggs["City"].Buckets[0]
+	assert.Equal(t, "深圳", city.Key)
+	assert.Equal(t, 2, len(city.Aggs["Vip"].Buckets))
+	assert.Equal(t, true, city.Aggs["Vip"].Buckets[0].Key)
+	assert.Equal(t, uint64(2), city.Aggs["Vip"].Buckets[0].Count)
+	assert.Equal(t, "food", city.Aggs["Tags"].Buckets[0].Key)
+	assert.Equal(t, uint64(2), city.Aggs["Tags"].Buckets[0].Count)
+	assert.Equal(t, int64(1), aggs["levels"].Buckets[0].Key)
+
+	for _, tt := range []struct {
+		name string
+		aggs []index.Agg
+	}{
+		{name: "unknown-field", aggs: []index.Agg{index.TermsAgg{Field: "Nope"}}},
+		{name: "duplicate", aggs: []index.Agg{index.TermsAgg{Field: "City"}, index.TermsAgg{Field: "City"}}},
+		{name: "nested-unknown", aggs: []index.Agg{index.TermsAgg{Field: "City", Aggs: []index.Agg{index.TermsAgg{Field: "Nope"}}}}},
+	} {
+		if _, err := i.Aggregate(`Level >= 1`, tt.aggs...); err == nil {
+			t.Errorf("Index.Aggregate() %s expected an error", tt.name)
+		}
+	}
+}
+
+func TestIndex_AggregateNumeric(t *testing.T) {
+	i := buildShops(t)
+
+	aggs, err := i.Aggregate(`Level >= 1`,
+		index.StatsAgg{Field: "Level"},
+		index.StatsAgg{Name: "joined", Field: "Joined"},
+		index.PercentilesAgg{Name: "p", Field: "Level", Percents: []float64{0, 50, 90, 100}},
+		index.HistogramAgg{Name: "histogram", Field: "Level", Interval: 2, Aggs: []index.Agg{index.TermsAgg{Field: "City"}}},
+		index.HistogramAgg{Name: "days", Field: "Joined", Interval: float64(24 * time.Hour)},
+		index.RangeAgg{Name: "ranges", Field: "Level", Ranges: []index.AggRange{
+			{From: math.Inf(-1), To: 2}, {From: 2, To: math.Inf(1)}, {Key: "1~2", From: 1, To: 3},
+		}})
+	if err != nil {
+		t.Fatalf("Index.Aggregate() error = %v", err)
+	}
+
+	assert.Equal(t, &index.Stats{Count: 6, Min: int64(1), Max: int64(3), Sum: 10, Avg: 10.0 / 6}, aggs["Level"].Stats)
+	assert.Equal(t, &index.Stats{Count: 6, Min: jan, Max: feb, Avg: jan.Add(feb.Sub(jan) / 2)}, aggs["joined"].Stats)
+	// integers are interpolated to the nearest integer
+	assert.Equal(t, []index.Percentile{{0, int64(1)}, {50, int64(2)}, {90, int64(3)}, {100, int64(3)}}, aggs["p"].Percentiles)
+
+	histogram := aggs["histogram"].Buckets
+	assert.Equal(t, 2, len(histogram))
+	assert.Equal(t, []interface{}{int64(0), uint64(3)}, []interface{}{histogram[0].Key, histogram[0].Count})
+	assert.Equal(t, []interface{}{int64(2), uint64(3)}, []interface{}{histogram[1].Key, histogram[1].Count})
+	assert.Equal(t, 3, len(histogram[0].Aggs["City"].Buckets)) // one shop of level 1 in each city
+	assert.Equal(t, "深圳", histogram[1].Aggs["City"].Buckets[0].Key)
+	assert.Equal(t, uint64(2), histogram[1].Aggs["City"].Buckets[0].Count)
+
+	days := aggs["days"].Buckets
+	assert.Equal(t, 2, len(days))
+	assert.Equal(t, []interface{}{jan, uint64(3)}, []interface{}{days[0].Key, days[0].Count})
+	assert.Equal(t, []interface{}{feb, uint64(3)}, []interface{}{days[1].Key, days[1].Count})
+
+	ranges := aggs["ranges"].Buckets
+	assert.Equal(t, []interface{}{"-2", uint64(3)}, []interface{}{ranges[0].Key, ranges[0].Count})
+	assert.Equal(t, []interface{}{"2-", uint64(3)}, []interface{}{ranges[1].Key, ranges[1].Count})
+	assert.Equal(t, []interface{}{"1~2", uint64(5)}, []interface{}{ranges[2].Key, ranges[2].Count})
+
+	aggs, err = i.Aggregate(`City == "nope"`, index.StatsAgg{Field: "Level"}, index.PercentilesAgg{Field: "Joined"})
+	if err != nil {
+		t.Fatalf("Index.Aggregate() error = %v", err)
+	}
+	assert.Equal(t, &index.Stats{}, aggs["Level"].Stats)
+	assert.Equal(t, 0, len(aggs["Joined"].Percentiles))
+
+	for _, tt := range []struct {
+		name string
+		agg  index.Agg
+	}{
+		{name: "string-field", agg: index.StatsAgg{Field: "City"}},
+		{name: "bool-field", agg: index.HistogramAgg{Field: "Vip", Interval: 1}},
+		{name: "interval", agg: index.HistogramAgg{Field: "Level"}},
+		{name: "fractional-interval", agg: index.HistogramAgg{Field: "Level", Interval: 0.5}},
+		{name: "percent", agg: index.PercentilesAgg{Field: "Level", Percents: []float64{101}}},
+		{name: "no-range", agg: index.RangeAgg{Field: "Level"}},
+		{name: "empty-range", agg: index.RangeAgg{Field: "Level", Ranges: []index.AggRange{{From: 2, To: 2}}}},
+	} {
+		if _, err := i.Aggregate(`Level >= 1`, tt.agg); err == nil {
+			t.Errorf("Index.Aggregate() %s expected an error", tt.name)
+		}
+	}
+}
+
+func TestIndex_AggregateLargeNumbers(t *testing.T) {
+	type Meter struct {
+		Big  uint64 `index:"on"`
+		Nano int64  `index:"on"`
+	}
+	const maxNano = math.MaxInt64 - 1
+	i := buildIndex(t, []string{"1", "2", "3"}, []interface{}{
+		Meter{math.MaxUint64, maxNano},
+		Meter{math.MaxUint64 - 1, maxNano - 1},
+		Meter{math.MaxUint64 - 3, maxNano - 3},
+	}, nil)
+
+	aggs, err := i.Aggregate(`Big > 0`,
+		index.StatsAgg{Field: "Big"},
+		index.StatsAgg{Field: "Nano"},
+		index.PercentilesAgg{Name: "p", Field: "Big", Percents: []float64{0, 50, 75, 100}},
+		index.HistogramAgg{Name: "big_histogram", Field: "Big", Interval: 2},
+		index.HistogramAgg{Name: "nano_histogram", Field: "Nano", Interval: 4},
+		index.RangeAgg{Name: "range", Field: "Big", Ranges: []index.AggRange{{From: float64(math.MaxUint64), To: math.Inf(1)}}})
+	if err != nil {
+		t.Fatalf("Index.Aggregate() error = %v", err)
+	}
+
+	stats := aggs["Big"].Stats
+	assert.Equal(t, []interface{}{uint64(math.MaxUint64 - 3), uint64(math.MaxUint64)}, []interface{}{stats.Min, stats.Max})
+	assert.Equal(t, 3*float64(math.MaxUint64), stats.Sum)
+	stats = aggs["Nano"].Stats
+	assert.Equal(t, []interface{}{int64(maxNano - 3), int64(maxNano)}, []interface{}{stats.Min, stats.Max})
+
+	assert.Equal(t, []index.Percentile{
+		{0, uint64(math.MaxUint64 - 3)}, {50, uint64(math.MaxUint64 - 1)}, {75, uint64(math.MaxUint64)}, {100, uint64(math.MaxUint64)},
+	}, aggs["p"].Percentiles)
+
+	keys := func(buckets []*index.Bucket) []interface{} {
+		got := make([]interface{}, 0, len(buckets))
+		for _, b := range buckets {
+			got = append(got, b.Key, b.Count)
+		}
+		return got
+	}
+	assert.Equal(t, []interface{}{uint64(math.MaxUint64 - 3), uint64(1), uint64(math.MaxUint64 - 1), uint64(2)}, keys(aggs["big_histogram"].Buckets))
+	assert.Equal(t, []interface{}{int64(maxNano - 6), uint64(1), int64(maxNano - 2), uint64(2)}, keys(aggs["nano_histogram"].Buckets))
+	assert.Equal(t, uint64(0), aggs["range"].Buckets[0].Count) // 2^64, above all the values
+}
@@ -1 +1,261 @@
 package index
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 文本分析：文本字段（`index:"text,analyzer=standard"`）的值在构建索引时由分析器切分为若干词元（token），每个词元
+// 作为一个 term 建立倒排索引；查询值使用同一分析器处理，因此 `Desc == "Quick Foxes"` 可以匹配包含 `quick` 与
+// `foxes` 两个词元的文档。
+//
+// 分析器由三部分组成，依次执行：
+//   - CharFilter: 在切词前对原始文本进行处理，如字符替换
+//   - Tokenizer: 将文本切分为词元
+//   - TokenFilter: 对词元进行处理，如转小写、去除重音符号、去除停用词
+
+// Token is a term produced by an analyzer
+type Token struct {
+	Term     string
+	Position int // position of the token in the token stream, starting from 0, stop words leave holes
+	Start    int // byte offset of the token in the text, after the char filters
+	End      int
+}
+
+// CharFilter 在切词前处理原始文本
+type CharFilter interface {
+	Filter(text string) string
+}
+
+// Tokenizer 将文本切分为词元
+type Tokenizer interface {
+	Tokenize(text string) []Token
+}
+
+// TokenFilter 处理词元，可以修改、删除或新增词元
+type TokenFilter interface {
+	Filter(tokens []Token) []Token
+}
+
+// Analyzer 将文本分析为词元
+type Analyzer interface {
+	Analyze(text string) []Token
+}
+
+// CustomAnalyzer chains char filters, a tokenizer and token filters
+type CustomAnalyzer struct {
+	CharFilters  []CharFilter
+	Tokenizer    Tokenizer
+	TokenFilters []TokenFilter
+}
+
+func (a *CustomAnalyzer) Analyze(text string) []Token {
+	for _, cf := range a.CharFilters {
+		text = cf.Filter(text)
+	}
+
+	tokens := a.Tokenizer.Tokenize(text)
+	for _, tf := range a.TokenFilters {
+		tokens = tf.Filter(tokens)
+	}
+	return tokens
+}
+
+// WhitespaceTokenizer 按照空白字符切词
+type WhitespaceTokenizer struct{}
+
+func (WhitespaceTokenizer) Tokenize(text string) []Token {
+	return tokenizeRuns(text, func(r rune) bool { return !unicode.IsSpace(r) })
+}
+
+// UnicodeTokenizer 按照 unicode 字母与数字切词，标点符号与空白字符作为分隔符
+type UnicodeTokenizer struct{}
+
+func (UnicodeTokenizer) Tokenize(text string) []Token {
+	return tokenizeRuns(text, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) })
+}
+
+// tokenizeRuns returns the maximal runs of runes that are part of a token
+func tokenizeRuns(text string, inToken func(r rune) bool) []Token {
+	var tokens []Token
+	start := -1
+	for i, r := range text {
+		switch in := inToken(r); {
+		case in && start < 0:
+			start = i
+		case !in && start >= 0:
+			tokens = append(tokens, Token{Term: text[start:i], Position: len(tokens), Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, Token{Term: text[start:], Position: len(tokens), Start: start, End: len(text)})
+	}
+	return tokens
+}
+
+// MappingCharFilter 按照映射表替换字符串，如：NewMappingCharFilter("&", " and ")
+type MappingCharFilter struct {
+	replacer *strings.Replacer
+}
+
+// NewMappingCharFilter 参数为若干个 old, new 字符串对
+func NewMappingCharFilter(oldnew ...string) *MappingCharFilter {
+	return &MappingCharFilter{replacer: strings.NewReplacer(oldnew...)}
+}
+
+func (f *MappingCharFilter) Filter(text string) string {
+	return f.replacer.Replace(text)
+}
+
+// LowercaseFilter 词元转小写
+type LowercaseFilter struct{}
+
+func (LowercaseFilter) Filter(tokens []Token) []Token {
+	for i := range tokens {
+		tokens[i].Term = strings.ToLower(tokens[i].Term)
+	}
+	return tokens
+}
+
+// ASCIIFoldingFilter 将带重音符号的拉丁字母转换为对应的 ASCII 字母，如：`café` -> `cafe`
+type ASCIIFoldingFilter struct{}
+
+func (ASCIIFoldingFilter) Filter(tokens []Token) []Token {
+	for i := range tokens {
+		tokens[i].Term = foldASCII(tokens[i].Term)
+	}
+	return tokens
+}
+
+func foldASCII(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if unicode.Is(unicode.Mn, r) { // combining diacritical marks
+			continue
+		}
+		if folded, ok := asciiFolding[r]; ok {
+			b.WriteString(folded)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// asciiFolding covers the Latin-1 Supplement and Latin Extended-A letters
+var asciiFolding = func() map[rune]string {
+	m := make(map[rune]string)
+	for folded, runes := range map[string]string{
+		"A": "ÀÁÂÃÄÅĀĂĄ", "a": "àáâãäåāăą", "AE": "Æ", "ae": "æ",
+		"C": "ÇĆĈĊČ", "c": "çćĉċč", "D": "ĎĐÐ", "d": "ďđð",
+		"E": "ÈÉÊËĒĔĖĘĚ", "e": "èéêëēĕėęě", "G": "ĜĞĠĢ", "g": "ĝğġģ",
+		"H": "ĤĦ", "h": "ĥħ", "I": "ÌÍÎÏĨĪĬĮİ", "i": "ìíîïĩīĭįı", "IJ": "Ĳ", "ij": "ĳ",
+		"J": "Ĵ", "j": "ĵ", "K": "Ķ", "k": "ķĸ", "L": "ĹĻĽĿŁ", "l": "ĺļľŀł",
+		"N": "ÑŃŅŇŊ", "n": "ñńņňŉŋ", "O": "ÒÓÔÕÖØŌŎŐ", "o": "òóôõöøōŏő", "OE": "Œ", "oe": "œ",
+		"R": "ŔŖŘ", "r": "ŕŗř", "S": "ŚŜŞŠ", "s": "śŝşšſ", "ss": "ß",
+		"T": "ŢŤŦ", "t": "ţťŧ", "TH": "Þ", "th": "þ",
+		"U": "ÙÚÛÜŨŪŬŮŰŲ", "u": "ùúûüũūŭůűų", "W": "Ŵ", "w": "ŵ",
+		"Y": "ÝŶŸ", "y": "ýÿŷ", "Z": "ŹŻŽ", "z": "źżž",
+	} {
+		for _, r := range runes {
+			m[r] = folded
+		}
+	}
+	return m
+}()
+
+// StopWordsFilter 去除停用词，停用词需要与经过前面的过滤器处理后的词元一致（如小写）。被去除的词元不改变其余词元的位置
+type StopWordsFilter struct {
+	words map[string]struct{}
+}
+
+func NewStopWordsFilter(words ...string) *StopWordsFilter {
+	f := &StopWordsFilter{words: make(map[string]struct{}, len(words))}
+	for _, w := range words {
+		f.words[w] = struct{}{}
+	}
+	return f
+}
+
+func (f *StopWordsFilter) Filter(tokens []Token) []Token {
+	kept := tokens[:0]
+	for _, tok := range tokens {
+		if _, ok := f.words[tok.Term]; !ok {
+			kept = append(kept, tok)
+		}
+	}
+	return kept
+}
+
+// EnglishStopWords are the stop words of the `english` analyzer
+var EnglishStopWords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in", "into", "is", "it", "no", "not",
+	"of", "on", "or", "such", "that", "the", "their", "then", "there", "these", "they", "this", "to", "was",
+	"will", "with",
+}
+
+// 内置分析器：
+//   - whitespace: 按空白字符切词，不做其它处理
+//   - simple: 按 unicode 字母与数字切词，转小写
+//   - standard: 按 unicode 字母与数字切词，转小写，去除重音符号
+//   - english: 在 standard 的基础上去除英文停用词
+//   - cjk: 中文按词典分词，其它文字同 standard，见 CJKTokenizer
+var (
+	analyzersMu sync.RWMutex
+	analyzers   = map[string]Analyzer{
+		"whitespace": &CustomAnalyzer{Tokenizer: WhitespaceTokenizer{}},
+		"simple":     &CustomAnalyzer{Tokenizer: UnicodeTokenizer{}, TokenFilters: []TokenFilter{LowercaseFilter{}}},
+		"standard": &CustomAnalyzer{
+			Tokenizer:    UnicodeTokenizer{},
+			TokenFilters: []TokenFilter{LowercaseFilter{}, ASCIIFoldingFilter{}},
+		},
+		"english": &CustomAnalyzer{
+			Tokenizer:    UnicodeTokenizer{},
+			TokenFilters: []TokenFilter{LowercaseFilter{}, ASCIIFoldingFilter{}, NewStopWordsFilter(EnglishStopWords...)},
+		},
+		"cjk": NewCJKAnalyzer(nil),
+	}
+)
+
+// DefaultAnalyzer is the analyzer of the text fields whose tag doesn't name one
+const DefaultAnalyzer = "standard"
+
+// RegisterAnalyzer 注册自定义分析器，字段 tag 中通过 `index:"text,analyzer=name"` 使用
+func RegisterAnalyzer(name string, analyzer Analyzer) error {
+	analyzersMu.Lock()
+	defer analyzersMu.Unlock()
+
+	if _, ok := analyzers[name]; ok {
+		return fmt.Errorf("analyzer %s already registered", name)
+	}
+	analyzers[name] = analyzer
+	return nil
+}
+
+// GetAnalyzer 获取已注册的分析器
+func GetAnalyzer(name string) (Analyzer, error) {
+	analyzersMu.RLock()
+	defer analyzersMu.RUnlock()
+
+	analyzer, ok := analyzers[name]
+	if !ok {
+		return nil, fmt.Errorf("analyzer %s not registered", name)
+	}
+	return analyzer, nil
+}
@@ -0,0 +1,230 @@
+package index
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 中文分词：基于词典的正向、逆向最大匹配，取两者中切分结果更好的一个（词元更少、单字更少，相同时取逆向）。
+// 词典中没有的连续单字按照二元组（bigram）切分，如 `腾讯大厦` 在词典只有 `腾讯` 时切分为 `腾讯` `大厦`，
+// 只有单字时切分为 `腾讯` `讯大` `大厦`。非中日韩文字按照字母与数字切词并转小写。
+//
+// 同一字段的文档与查询值必须使用同一词典切分，修改词典后需要重建相关字段的索引。
+
+//go:embed dict/zh.txt
+var zhDict string
+
+// DefaultCJKDictionary 内置的小型中文词典，`cjk` 分析器使用，可以通过 Add 或 Load 添加用户词典
+var DefaultCJKDictionary = func() *Dictionary {
+	d := NewDictionary()
+	d.Load(strings.NewReader(zhDict))
+	return d
+}()
+
+// Dictionary 分词词典，可以被并发使用
+type Dictionary struct {
+	mu     sync.RWMutex
+	words  map[string]struct{}
+	maxLen int // length in runes of the longest word
+}
+
+func NewDictionary(words ...string) *Dictionary {
+	d := &Dictionary{words: make(map[string]struct{}, len(words))}
+	d.Add(words...)
+	return d
+}
+
+// Add 添加词语
+func (d *Dictionary) Add(words ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w == "" {
+			continue
+		}
+		d.words[w] = struct{}{}
+		if n := utf8.RuneCountInString(w); n > d.maxLen {
+			d.maxLen = n
+		}
+	}
+}
+
+// Load 从 r 中读取词语，每行一个词，行内空白之后的内容（如词频）以及 `#` 开头的行被忽略
+func (d *Dictionary) Load(r io.Reader) error {
+	var words []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, strings.Fields(line)[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	d.Add(words...)
+	return nil
+}
+
+// Contains 判断词语是否在词典中
+func (d *Dictionary) Contains(word string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	_, ok := d.words[word]
+	return ok
+}
+
+func (d *Dictionary) maxWordLen() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.maxLen
+}
+
+// CJKTokenizer 中日韩文字按词典切词，其它文字按照字母与数字切词
+type CJKTokenizer struct {
+	Dict *Dictionary
+}
+
+func (t CJKTokenizer) Tokenize(text string) []Token {
+	var tokens []Token
+	emit := func(start, end int) {
+		tokens = append(tokens, Token{Term: text[start:end], Position: len(tokens), Start: start, End: end})
+	}
+
+	// split the text into runs of CJK chars and runs of other letters and digits
+	start, cjkRun := -1, false
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		if cjkRun {
+			for _, w := range t.segment(text[start:end]) {
+				emit(start+w[0], start+w[1])
+			}
+		} else {
+			emit(start, end)
+		}
+		start = -1
+	}
+	for i, r := range text {
+		switch {
+		case isCJK(r):
+			if start >= 0 && !cjkRun {
+				flush(i)
+			}
+			if start < 0 {
+				start, cjkRun = i, true
+			}
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			if start >= 0 && cjkRun {
+				flush(i)
+			}
+			if start < 0 {
+				start, cjkRun = i, false
+			}
+		default:
+			flush(i)
+		}
+	}
+	flush(len(text))
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// segment splits a run of CJK chars into words, returned as [start, end) byte offsets in the run
+func (t CJKTokenizer) segment(run string) [][2]int {
+	offsets := make([]int, 0, len(run)/3+1) // byte offset of every rune, and of the end of the run
+	for i := range run {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(run))
+	n := len(offsets) - 1
+
+	dict := t.Dict
+	if dict == nil {
+		dict = DefaultCJKDictionary
+	}
+	maxLen := dict.maxWordLen()
+	word := func(from, to int) bool { return dict.Contains(run[offsets[from]:offsets[to]]) }
+
+	// forward and backward maximum matching, as rune index ranges
+	var fwd, bwd [][2]int
+	for i := 0; i < n; {
+		l := min(maxLen, n-i)
+		for ; l > 1 && !word(i, i+l); l-- {
+		}
+		l = max(l, 1)
+		fwd = append(fwd, [2]int{i, i + l})
+		i += l
+	}
+	for j := n; j > 0; {
+		l := min(maxLen, j)
+		for ; l > 1 && !word(j-l, j); l-- {
+		}
+		l = max(l, 1)
+		bwd = append(bwd, [2]int{j - l, j})
+		j -= l
+	}
+	for a, b := 0, len(bwd)-1; a < b; a, b = a+1, b-1 {
+		bwd[a], bwd[b] = bwd[b], bwd[a]
+	}
+
+	singles := func(words [][2]int) int {
+		cnt := 0
+		for _, w := range words {
+			if w[1]-w[0] == 1 {
+				cnt++
+			}
+		}
+		return cnt
+	}
+	words := bwd
+	if len(fwd) < len(bwd) || len(fwd) == len(bwd) && singles(fwd) < singles(bwd) {
+		words = fwd
+	}
+
+	// runs of unknown single chars fall back to bigrams
+	var res [][2]int
+	for k := 0; k < len(words); {
+		w := words[k]
+		if w[1]-w[0] > 1 || word(w[0], w[1]) {
+			res = append(res, [2]int{offsets[w[0]], offsets[w[1]]})
+			k++
+			continue
+		}
+
+		end := k
+		for end < len(words) && words[end][1]-words[end][0] == 1 && !word(words[end][0], words[end][1]) {
+			end++
+		}
+		if end-k == 1 {
+			res = append(res, [2]int{offsets[w[0]], offsets[w[1]]})
+		}
+		for b := k; b+1 < end; b++ {
+			res = append(res, [2]int{offsets[words[b][0]], offsets[words[b+1][1]]})
+		}
+		k = end
+	}
+	return res
+}
+
+// NewCJKAnalyzer 使用指定词典的中文分析器，dict 为 nil 时使用 DefaultCJKDictionary，可以通过 RegisterAnalyzer 注册
+func NewCJKAnalyzer(dict *Dictionary) Analyzer {
+	return &CustomAnalyzer{
+		Tokenizer:    CJKTokenizer{Dict: dict},
+		TokenFilters: []TokenFilter{LowercaseFilter{}, ASCIIFoldingFilter{}},
+	}
+}
@@ -0,0 +1,89 @@
+package index_test
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+	"github.com/chirlchen/pans/index"
+)
+
+func TestAnalyzers(t *testing.T) {
+	tests := []struct {
+		analyzer string
+		text     string
+		want     []string
+	}{
+		{analyzer: "whitespace", text: " The  Quick-Brown\tfox ", want: []string{"The", "Quick-Brown", "fox"}},
+		{analyzer: "simple", text: "The Quick-Brown fox, 2024!", want: []string{"the", "quick", "brown", "fox", "2024"}},
+		{analyzer: "standard", text: "Crème Brûlée at the Café", want: []string{"creme", "brulee", "at", "the", "cafe"}},
+		{analyzer: "standard", text: "Straße Œuvre", want: []string{"strasse", "oeuvre"}},
+		{analyzer: "english", text: "The Quick fox is in the Café", want: []string{"quick", "fox", "cafe"}},
+		{analyzer: "english", text: "to be or not to be", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.analyzer+"/"+tt.text, func(t *testing.T) {
+			analyzer, err := index.GetAnalyzer(tt.analyzer)
+			if err != nil {
+				t.Fatalf("GetAnalyzer() error = %v", err)
+			}
+			got := []string{}
+			for _, tok := range analyzer.Analyze(tt.text) {
+				got = append(got, tok.Term)
+			}
+			assert.Equalf(t, tt.want, got, "Analyze(%q) got: %v", tt.text, got)
+		})
+	}
+}
+
+func TestCustomAnalyzer(t *testing.T) {
+	analyzer := &index.CustomAnalyzer{
+		CharFilters:  []index.CharFilter{index.NewMappingCharFilter("&", " and ")},
+		Tokenizer:    index.WhitespaceTokenizer{},
+		TokenFilters: []index.TokenFilter{index.LowercaseFilter{}, index.NewStopWordsFilter("and")},
+	}
+	tokens := analyzer.Analyze("Salt&Pepper Chips")
+	want := []index.Token{
+		{Term: "salt", Position: 0, Start: 0, End: 4},
+		{Term: "pepper", Position: 2, Start: 9, End: 15},
+		{Term: "chips", Position: 3, Start: 16, End: 21},
+	}
+	assert.Equalf(t, want, tokens, "Analyze() got: %v", tokens)
+
+	if err := index.RegisterAnalyzer("standard", analyzer); err == nil {
+		t.Errorf("RegisterAnalyzer() of a registered name expected an error")
+	}
+}
+
+func TestCJKTokenizer(t *testing.T) {
+	dict := index.NewDictionary("深圳", "南山", "南山区", "科技", "科技园", "研究", "研究生", "生命", "起源", "腾讯")
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{name: "words", text: "深圳南山", want: []string{"深圳", "南山"}},
+		{name: "longest", text: "深圳南山区科技园", want: []string{"深圳", "南山区", "科技园"}},
+		{name: "backward", text: "研究生命起源", want: []string{"研究", "生命", "起源"}},
+		{name: "bigram-fallback", text: "腾讯滨海大厦", want: []string{"腾讯", "滨海", "海大", "大厦"}},
+		{name: "unknown-single", text: "深圳市", want: []string{"深圳", "市"}},
+		{name: "mixed", text: "腾讯Tencent总部，位于深圳", want: []string{"腾讯", "tencent", "总部", "位于", "深圳"}},
+		{name: "empty", text: "，。！", want: []string{}},
+	}
+	analyzer := index.NewCJKAnalyzer(dict)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, tok := range analyzer.Analyze(tt.text) {
+				got = append(got, tok.Term)
+			}
+			assert.Equalf(t, tt.want, got, "Analyze(%q) got: %v", tt.text, got)
+		})
+	}
+
+	dict.Add("滨海大厦")
+	got := []string{}
+	for _, tok := range analyzer.Analyze("腾讯滨海大厦") {
+		got = append(got, tok.Term)
+	}
+	assert.Equalf(t, []string{"腾讯", "滨海大厦"}, got, "Analyze() with a user word got: %v", got)
+}
@@ -0,0 +1,260 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 查询解释：Index.Explain 执行查询并返回与查询语法树对应的执行计划，每个条件节点上标注了使用的索引、在各个段上匹配的
+// 内部文档数之和、耗时，以及 like、prefix 等条件在词典（FST）中展开的词项，用于排查查询结果不符合预期的原因。
+// Explanation 的 String 方法输出文本格式，也可以直接序列化为 JSON。
+//
+//	exp, err := idx.Explain(`Age > 20 && like(Name.First, "vic.*")`)
+//	fmt.Println(exp)
+
+// MaxExplainTerms is the number of expanded terms an ExplainNode lists, TermCount counts them all
+const MaxExplainTerms = 20
+
+// Explanation is the plan of a query along with the statistics of its execution
+type Explanation struct {
+	Query   string        `json:"query"`
+	Docs    int           `json:"docs"` // docs matched by the query, deleted docs excluded
+	Elapsed time.Duration `json:"elapsed_ns"`
+	Plan    *ExplainNode  `json:"plan"`
+}
+
+// ExplainNode is a condition of the query, the parentheses of the query aren't nodes and the conditions of a chain
+// of `&&` are the children of a single node. The Docs of an `and_not` node, a negated condition of an `&&`, are the
+// docs it removed from the result of the other conditions.
+type ExplainNode struct {
+	Kind  string `json:"kind"`            // and, or, not, and_not, term, range, regex, prefix, fuzzy, match, in_array or func
+	Expr  string `json:"expr"`            // text of the condition in the query
+	Field string `json:"field,omitempty"` // field the condition reads
+	Index string `json:"index,omitempty"` // index of the field the condition is evaluated with: term, range, bool or text
+
+	Docs      uint64        `json:"docs"`                 // internal docs matched, summed over the segments, deleted docs included
+	Estimate  uint64        `json:"estimate"`             // docs the planner estimated the condition of an `&&` matches
+	Skipped   int           `json:"skipped,omitempty"`    // segments the condition of an `&&` wasn't evaluated on, nothing was left
+	Terms     []string      `json:"terms,omitempty"`      // terms of the term dictionary the condition expanded to, at most MaxExplainTerms
+	TermCount int           `json:"term_count,omitempty"` // number of terms expanded
+	Elapsed   time.Duration `json:"elapsed_ns"`           // time spent on the condition, summed over the segments
+
+	Children []*ExplainNode `json:"children,omitempty"`
+
+	terms map[string]struct{} // terms expanded on all the segments
+}
+
+// Explain 执行查询并返回执行计划以及每个条件的匹配文档数、耗时与展开的词项
+func (i *Index) Explain(query string) (*Explanation, error) {
+	return i.ExplainWithParams(query, nil)
+}
+
+// ExplainWithParams 使用参数执行查询并返回执行计划，见 Explain 以及 QueryWithParams
+func (i *Index) ExplainWithParams(query string, params Params) (*Explanation, error) {
+	pq, err := i.parse(query)
+	if err != nil {
+		return nil, err
+	}
+	pq.explain = make(map[ast.Expr]*ExplainNode)
+	plan := explainTree(pq, query, i.mapping, pq.expr)
+
+	start := time.Now()
+	res, err := i.snap.Load().run(pq, params)
+	if err != nil {
+		return nil, err
+	}
+	exp := &Explanation{Query: query, Docs: len(res.ExternalDocIDs), Elapsed: time.Since(start), Plan: plan}
+	for _, node := range pq.explain {
+		node.collectTerms()
+	}
+	return exp, nil
+}
+
+// explainTree builds the node of the expression and of its sub conditions, the nodes are indexed by expression
+// so that qeval finds them
+func explainTree(pq *parsedQuery, query string, mp *Mapping, expr ast.Expr) *ExplainNode {
+	if paren, ok := expr.(*ast.ParenExpr); ok {
+		return explainTree(pq, query, mp, paren.X)
+	}
+
+	node := &ExplainNode{
+		Expr:  query[pq.fset.Position(expr.Pos()).Offset:pq.fset.Position(expr.End()).Offset],
+		terms: make(map[string]struct{}),
+	}
+	pq.explain[expr] = node
+	var field ast.Expr
+	switch expr := expr.(type) {
+	case *ast.BinaryExpr:
+		switch expr.Op {
+		case token.LAND: // the conditions of a chain of `&&` are planned together, see evalAnd
+			node.Kind = "and"
+			for _, c := range andClauses(expr, nil) {
+				child := explainTree(pq, query, mp, c.node())
+				if c.not != nil {
+					child.Kind = "and_not"
+				}
+				node.Children = append(node.Children, child)
+			}
+		case token.LOR:
+			node.Kind = "or"
+			node.Children = []*ExplainNode{explainTree(pq, query, mp, expr.X), explainTree(pq, query, mp, expr.Y)}
+		case token.EQL, token.NEQ:
+			node.Kind, field = "term", expr.X
+		default:
+			node.Kind, field = "range", expr.X
+		}
+	case *ast.UnaryExpr:
+		node.Kind = "not"
+		node.Children = []*ExplainNode{explainTree(pq, query, mp, expr.X)}
+	case *ast.Ident, *ast.SelectorExpr:
+		node.Kind, field = "term", expr
+	case *ast.CallExpr:
+		node.Kind = "func"
+		if fn, ok := expr.Fun.(*ast.Ident); ok {
+			if kind, ok := explainFuncKinds[fn.Name]; ok && len(expr.Args) > 0 {
+				node.Kind, field = kind, expr.Args[0]
+			}
+		}
+	}
+
+	if field != nil {
+		node.Field, _ = parseIdent(field)
+		if fm, ok := mp.Field(node.Field); ok {
+			node.Index = explainIndexNames[fm.kind()]
+		}
+	}
+	return node
+}
+
+// explainFuncKinds are the kinds of the nodes of the built-in functions
+var explainFuncKinds = map[string]string{
+	"in_array": "in_array",
+	"like":     "regex",
+	"ilike":    "regex",
+	"iequals":  "regex",
+	"wildcard": "regex",
+	"prefix":   "prefix",
+	"fuzzy":    "fuzzy",
+	"between":  "range",
+	"match":    "match",
+}
+
+// explainIndexNames are the indexes the conditions on the fields are evaluated with, by kind of field
+var explainIndexNames = map[fieldKind]string{
+	kindString: "term",
+	kindText:   "text",
+	kindNumber: "range",
+	kindTime:   "range",
+	kindBool:   "bool",
+}
+
+// eval evaluates the condition of the node on a segment and records its statistics
+func (n *ExplainNode) eval(expr ast.Expr, ec *evalContext) (*SearchResults, error) {
+	c := *ec
+	c.ctx = withExpandedTerms(ec.ctx, n.terms)
+
+	start := time.Now()
+	res, err := evalExpr(expr, &c)
+	n.Elapsed += time.Since(start)
+	if res != nil {
+		n.Docs += res.internalDocIds.GetCardinality()
+	}
+	return res, err
+}
+
+// explainEstimate records the estimate of a condition of an `&&`
+func (ec *evalContext) explainEstimate(c clause) {
+	if node, ok := ec.explain[c.node()]; ok {
+		node.Estimate += c.estimate
+	}
+}
+
+// explainSkipped records the conditions of an `&&` the planner skipped
+func (ec *evalContext) explainSkipped(clauses ...[]clause) {
+	for _, cs := range clauses {
+		for _, c := range cs {
+			if node, ok := ec.explain[c.node()]; ok {
+				node.Skipped++
+			}
+		}
+	}
+}
+
+// explainAndNot records the docs a negated condition of an `&&` removed
+func (ec *evalContext) explainAndNot(c clause, removed uint64, elapsed time.Duration) {
+	if node, ok := ec.explain[c.node()]; ok {
+		node.Docs += removed
+		node.Elapsed += elapsed
+	}
+}
+
+func (n *ExplainNode) collectTerms() {
+	n.TermCount = len(n.terms)
+	for term := range n.terms {
+		n.Terms = append(n.Terms, term)
+	}
+	sort.Strings(n.Terms)
+	if len(n.Terms) > MaxExplainTerms {
+		n.Terms = n.Terms[:MaxExplainTerms]
+	}
+}
+
+type expandedTermsKey struct{}
+
+// withExpandedTerms returns a context the term dictionary queries record the terms they expand to into
+func withExpandedTerms(ctx context.Context, terms map[string]struct{}) context.Context {
+	return context.WithValue(ctx, expandedTermsKey{}, terms)
+}
+
+// expandedTerms returns the set the terms expanded are recorded into, nil when the query isn't explained
+func expandedTerms(ctx context.Context) map[string]struct{} {
+	terms, _ := ctx.Value(expandedTermsKey{}).(map[string]struct{})
+	return terms
+}
+
+// String formats the explanation as a tree, one condition per line
+func (e *Explanation) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s (docs=%d elapsed=%s)\n", e.Query, e.Docs, e.Elapsed)
+	e.Plan.format(&sb, "", "", false)
+	return sb.String()
+}
+
+func (n *ExplainNode) format(sb *strings.Builder, prefix, childPrefix string, planned bool) {
+	sb.WriteString(prefix)
+	sb.WriteString(n.Kind)
+	if n.Kind != "and" && n.Kind != "or" && n.Kind != "not" && n.Kind != "and_not" {
+		fmt.Fprintf(sb, " %s", n.Expr)
+	}
+	if n.Index != "" {
+		fmt.Fprintf(sb, " [%s]", n.Index)
+	}
+	fmt.Fprintf(sb, " docs=%d", n.Docs)
+	if planned {
+		fmt.Fprintf(sb, " estimate=%d", n.Estimate)
+	}
+	if n.Skipped > 0 {
+		fmt.Fprintf(sb, " skipped=%d", n.Skipped)
+	}
+	fmt.Fprintf(sb, " elapsed=%s", n.Elapsed)
+	if n.TermCount > 0 {
+		fmt.Fprintf(sb, " terms=%d %s", n.TermCount, strings.Join(n.Terms, ","))
+		if n.TermCount > len(n.Terms) {
+			sb.WriteString(",...")
+		}
+	}
+	sb.WriteByte('\n')
+
+	for j, child := range n.Children {
+		if j == len(n.Children)-1 {
+			child.format(sb, childPrefix+"└─ ", childPrefix+"   ", n.Kind == "and")
+		} else {
+			child.format(sb, childPrefix+"├─ ", childPrefix+"│  ", n.Kind == "and")
+		}
+	}
+}
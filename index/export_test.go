@@ -0,0 +1,20 @@
+package index
+
+// MergedSegments returns the number of sealed segments and whether the merge policy finds nothing left to merge
+func (i *Index) MergedSegments() (int, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	sizes := make([]uint64, 0, len(i.segments))
+	for _, seg := range i.segments {
+		sizes = append(sizes, seg.fullDocIDBits.GetCardinality()-seg.fullDocIDBits.AndCardinality(i.deleted))
+	}
+	_, _, ok := i.policy.findMerge(sizes)
+	return len(i.segments), !ok
+}
+
+// SetMergesDoneHook sets the function the merges call once they found nothing left to merge, while they still hold
+// the merge lock. It must be set before any segment is sealed.
+func (i *Index) SetMergesDoneHook(fn func()) {
+	i.mergesDone = fn
+}
@@ -3,9 +3,10 @@ package index
 import (
 	"fmt"
 	"reflect"
+	"strconv"
+	"time"
 
 	"github.com/araddon/qlbridge/value"
-	"github.com/spf13/cast"
 )
 
 type FloatValue = value.NumberValue
@@ -14,8 +15,30 @@ type IntSliceValue struct {
 	v []int64
 }
 
+type FloatSliceValue struct {
+	v []float64
+}
+
+// UintValue keeps the full range of unsigned integers, `value.IntValue` overflows above math.MaxInt64
+type UintValue struct {
+	v uint64
+}
+
+type UintSliceValue struct {
+	v []uint64
+}
+
+// DurationValue is the value of a `duration("24h")` query literal, it's only meant to shift time literals
+type DurationValue struct {
+	v time.Duration
+}
+
 const (
-	IntSliceType value.ValueType = 100
+	IntSliceType   value.ValueType = 100
+	FloatSliceType value.ValueType = 101
+	DurationType   value.ValueType = 102
+	UintType       value.ValueType = 103
+	UintSliceType  value.ValueType = 104
 )
 
 func (m IntSliceValue) Nil() bool                    { return m.v == nil }
@@ -26,21 +49,75 @@ func (m IntSliceValue) Val() []int64                 { return m.v }
 func (m IntSliceValue) MarshalJSON() ([]byte, error) { return nil, nil }
 func (m IntSliceValue) ToString() string             { return fmt.Sprintf("%v", m.v) }
 
+func (m FloatSliceValue) Nil() bool                    { return m.v == nil }
+func (m FloatSliceValue) Err() bool                    { return false }
+func (m FloatSliceValue) Type() value.ValueType        { return FloatSliceType }
+func (m FloatSliceValue) Value() interface{}           { return m.v }
+func (m FloatSliceValue) Val() []float64               { return m.v }
+func (m FloatSliceValue) MarshalJSON() ([]byte, error) { return nil, nil }
+func (m FloatSliceValue) ToString() string             { return fmt.Sprintf("%v", m.v) }
+
+func NewUintValue(v uint64) UintValue { return UintValue{v: v} }
+
+func (m UintValue) Nil() bool                    { return false }
+func (m UintValue) Err() bool                    { return false }
+func (m UintValue) Type() value.ValueType        { return UintType }
+func (m UintValue) Value() interface{}           { return m.v }
+func (m UintValue) Val() uint64                  { return m.v }
+func (m UintValue) MarshalJSON() ([]byte, error) { return nil, nil }
+func (m UintValue) ToString() string             { return strconv.FormatUint(m.v, 10) }
+
+func (m UintSliceValue) Nil() bool                    { return m.v == nil }
+func (m UintSliceValue) Err() bool                    { return false }
+func (m UintSliceValue) Type() value.ValueType        { return UintSliceType }
+func (m UintSliceValue) Value() interface{}           { return m.v }
+func (m UintSliceValue) Val() []uint64                { return m.v }
+func (m UintSliceValue) MarshalJSON() ([]byte, error) { return nil, nil }
+func (m UintSliceValue) ToString() string             { return fmt.Sprintf("%v", m.v) }
+
+func NewDurationValue(d time.Duration) DurationValue { return DurationValue{v: d} }
+
+func (m DurationValue) Nil() bool                    { return false }
+func (m DurationValue) Err() bool                    { return false }
+func (m DurationValue) Type() value.ValueType        { return DurationType }
+func (m DurationValue) Value() interface{}           { return m.v }
+func (m DurationValue) Val() time.Duration           { return m.v }
+func (m DurationValue) MarshalJSON() ([]byte, error) { return nil, nil }
+func (m DurationValue) ToString() string             { return m.v.String() }
+
 type StringSliceValue value.StringsValue
 
+// NewSliceValue converts a slice or an array to the value it's indexed with, according to its element kind
 func NewSliceValue(rval reflect.Value) value.Value {
-	goVal := rval.Interface()
-	switch val := goVal.(type) {
-	case []string:
-		return value.NewStringsValue(val)
-	case []int, []int8, []int16, []int32, []int64, []uint, []uint8, []uint16, []uint32, []uint64:
+	switch rval.Type().Elem().Kind() {
+	case reflect.String:
+		sarr := make([]string, 0, rval.Len())
+		for i := 0; i < rval.Len(); i++ {
+			sarr = append(sarr, rval.Index(i).String())
+		}
+
+		return value.NewStringsValue(sarr)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		iarr := make([]int64, 0, rval.Len())
 		for i := 0; i < rval.Len(); i++ {
-			iarr = append(iarr, cast.ToInt64(rval.Index(i).Interface()))
+			iarr = append(iarr, rval.Index(i).Int())
 		}
 
 		return IntSliceValue{v: iarr}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		uarr := make([]uint64, 0, rval.Len())
+		for i := 0; i < rval.Len(); i++ {
+			uarr = append(uarr, rval.Index(i).Uint())
+		}
+
+		return UintSliceValue{v: uarr}
+	case reflect.Float32, reflect.Float64:
+		farr := make([]float64, 0, rval.Len())
+		for i := 0; i < rval.Len(); i++ {
+			farr = append(farr, rval.Index(i).Float())
+		}
 
+		return FloatSliceValue{v: farr}
 	}
-	return value.NewErrorValue(fmt.Errorf("type:%T not supported index", goVal))
+	return value.NewErrorValue(fmt.Errorf("type:%s not supported index", rval.Type()))
 }
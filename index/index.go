@@ -5,80 +5,574 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/araddon/gou"
+	"github.com/tidwall/btree"
 )
 
 var (
 	ErrEOF = errors.New("EOF") // end of paging query, no more results
 )
 
+// Index 由若干个不可变的段（segment）以及一个可变的内存缓冲段组成：写入先进入缓冲段，缓冲段写满后被封存为不可变段，
+// 后台按照合并策略（MergePolicy）将小段合并成大段。查询会在所有段上执行，并通过 roaring bitmap 合并结果。
+//
+// Index 可以被并发使用：查询无锁地读取最近一次发布的不可变快照，写入串行执行，每次写入完成后原子地发布新快照。
+//
+// Internal doc ids are unique across all the segments of the index, so results of different segments can be
+// unioned directly and merging segments doesn't need to remap them.
 type Index struct {
-	docs map[string]interface{} // external doc id ->  data
-	raw  []interface{}          // original docs
+	snap atomic.Pointer[snapshot] // what the readers see
+
+	mu      sync.Mutex // serializes the writers, guards the fields below
+	mergeMu sync.Mutex // held by the running merge, only one merge at a time
+
+	mergePending atomic.Bool // segments were sealed since the merge policy last looked at them, see mergeBackground
+	mergesDone   func()      // called once the merges found nothing left to merge, mergeMu still held, tests only
+
+	docs *btree.Map[string, interface{}] // external doc id ->  data
+
+	mapping   *Mapping     // fields to index, built from the first document
+	preprocFn []Preprocess // applied to every document before it's saved and indexed
+	policy    MergePolicy
+
+	segments []*Segment      // sealed immutable segments, ordered by internal doc id
+	buffer   *Segment        // mutable segment receiving the writes
+	deleted  *roaring.Bitmap // tombstoned internal doc ids of the sealed segments, purged by merges
+
+	// the published snapshot shares the parts of the state that didn't change since, see publish
+	deletedShared bool     // deleted is held by a snapshot, it's cloned before being changed
+	bufferCopy    *Segment // copy of the buffer held by the published snapshot, nil once the buffer changed
+}
+
+func NewIndex(keys []string, docs []interface{}, preprocFn ...Preprocess) (*Index, error) {
+	idx := &Index{
+		docs:      btree.NewMap[string, interface{}](0),
+		preprocFn: preprocFn,
+		policy:    DefaultMergePolicy,
+		deleted:   roaring.New(),
+	}
+
+	if err := idx.insertDocs(keys, docs); err != nil {
+		return nil, err
+	}
+
+	idx.publish()
+	return idx, nil
+}
+
+// SetMergePolicy 设置缓冲段封存以及段合并的策略
+func (i *Index) SetMergePolicy(policy MergePolicy) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.policy = policy
+}
+
+// Upsert 新增或更新单个文档，只更新该文档相关的倒排索引，无需全量重建
+func (i *Index) Upsert(key string, doc interface{}) error {
+	document, doc, err := i.prepareDoc(key, doc)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
 
-	index *Segment // index of docs
+	// the new version goes to the buffer, the previous one living in a sealed segment is tombstoned only once the
+	// new one is indexed: a doc failing to be indexed leaves the index as it was
+	seg, inDocID, ok := i.locate(key)
+	i.bufferCopy = nil
+	if err := i.buffer.IndexDocuments(context.TODO(), []Document{document}); err != nil {
+		return err
+	}
+	if ok && seg != i.buffer {
+		i.tombstones().Add(inDocID)
+	}
+
+	i.docs.Set(key, doc)
+	if i.buffer.fullDocIDBits.GetCardinality() >= uint64(i.policy.FlushSize) {
+		i.flush()
+	}
+	i.publish()
+	return nil
 }
 
-func NewIndex(keys []string, docs []interface{}, preprocFn ...Preprocess) (Index, error) {
-	docCnt := int32(len(keys))
-	idx := Index{docs: make(map[string]interface{}, docCnt), index: NewSegment(docCnt)}
+// Delete 删除文档，不存在的 key 会被忽略
+func (i *Index) Delete(keys ...string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 
-	err := idx.insertDocs(keys, docs, preprocFn...)
-	return idx, err
+	buffered := make([]string, 0, len(keys))
+	for _, key := range keys {
+		seg, inDocID, ok := i.locate(key)
+		if !ok {
+			continue
+		}
+
+		if seg == i.buffer {
+			buffered = append(buffered, key)
+		} else {
+			i.tombstones().Add(inDocID)
+		}
+		i.docs.Delete(key)
+	}
+
+	if len(buffered) > 0 {
+		i.bufferCopy = nil
+	}
+	defer i.publish()
+	return i.buffer.DeleteDocuments(context.TODO(), buffered)
+}
+
+// Flush 将缓冲段封存为不可变段
+func (i *Index) Flush() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.flush()
+	i.publish()
+}
+
+// ForceMerge 同步将所有不可变段合并为一个段，并清理已删除的文档
+func (i *Index) ForceMerge() error {
+	i.mergeMu.Lock()
+	i.mu.Lock()
+	from, to := 0, len(i.segments)
+	i.mu.Unlock()
+
+	err := i.merge(from, to)
+	i.mergeMu.Unlock()
+	i.mergeBackground() // the segments sealed meanwhile, their background merge gave up on mergeMu
+	return err
+}
+
+// flush seals the buffer and starts a background merge when the merge policy finds one, i.mu must be held
+func (i *Index) flush() {
+	if i.buffer.fullDocIDBits.IsEmpty() {
+		return
+	}
+
+	i.buffer.seal()
+	i.segments = append(i.segments, i.buffer)
+	i.buffer = i.newSegment(i.buffer.docIdInc)
+	i.bufferCopy = nil
+	i.mergePending.Store(true)
+	go i.mergeBackground()
+}
+
+// mergeBackground runs the merges picked by the merge policy while segments are sealed. When a merge is already
+// running it returns right away: the running one checks mergePending once it released mergeMu, so a segment sealed
+// after it last looked at the segments isn't left unmerged.
+func (i *Index) mergeBackground() {
+	for i.mergePending.Load() {
+		if !i.mergeMu.TryLock() {
+			return
+		}
+		i.mergePending.Store(false)
+		err := i.runMerges()
+		i.mergeMu.Unlock()
+		if err != nil {
+			gou.Errorf("error merging segments: err:%v", err)
+			return
+		}
+	}
+}
+
+// runMerges runs the merges picked by the merge policy, until there are none left, i.mergeMu must be held
+func (i *Index) runMerges() error {
+	for {
+		i.mu.Lock()
+		sizes := make([]uint64, 0, len(i.segments))
+		for _, seg := range i.segments {
+			sizes = append(sizes, seg.fullDocIDBits.GetCardinality()-seg.fullDocIDBits.AndCardinality(i.deleted))
+		}
+		from, to, ok := i.policy.findMerge(sizes)
+		i.mu.Unlock()
+
+		if !ok {
+			if i.mergesDone != nil {
+				i.mergesDone()
+			}
+			return nil
+		}
+		if err := i.merge(from, to); err != nil {
+			return err
+		}
+	}
+}
+
+// merge replaces the sealed segments [from, to) with their merge, i.mergeMu must be held. Sealed segments are
+// immutable and only merges remove them, so the merged segment is built without blocking reads and writes.
+func (i *Index) merge(from, to int) error {
+	if to-from < 1 {
+		return nil
+	}
+
+	i.mu.Lock()
+	segs := append([]*Segment(nil), i.segments[from:to]...)
+	deleted := i.deleted
+	i.deletedShared = true
+	i.mu.Unlock()
+
+	merged, err := mergeSegments(segs, deleted)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// purged docs don't need the tombstones anymore, the ones deleted meanwhile are still applied to the merged one
+	for _, seg := range segs {
+		i.tombstones().AndNot(roaring.And(seg.fullDocIDBits, deleted))
+	}
+	segments := make([]*Segment, 0, len(i.segments)-len(segs)+1)
+	segments = append(segments, i.segments[:from]...)
+	segments = append(segments, merged)
+	i.segments = append(segments, i.segments[to:]...)
+	i.publish()
+	return nil
+}
+
+// publish atomically swaps in a snapshot of the current state, i.mu must be held. Nothing is copied but what
+// changed since the previous snapshot:
+//   - sealed segments are immutable and shared
+//   - the docs are a copy-on-write btree, copying it is O(1) and a later write only copies the nodes it changes
+//   - the tombstones are shared, a later write clones them first, see tombstones
+//   - the buffer keeps being written so the snapshot gets a copy of it, reused until the buffer changes. A write to
+//     the buffer costs a copy of at most FlushSize docs.
+func (i *Index) publish() {
+	segments := make([]*Segment, 0, len(i.segments)+1)
+	segments = append(segments, i.segments...)
+	if i.bufferCopy == nil && !i.buffer.fullDocIDBits.IsEmpty() {
+		i.bufferCopy = i.buffer.clone()
+	}
+	if i.bufferCopy != nil {
+		segments = append(segments, i.bufferCopy)
+	}
+
+	i.deletedShared = true
+	i.snap.Store(&snapshot{
+		docs:     i.docs.Copy(),
+		segments: segments,
+		deleted:  i.deleted,
+	})
+}
+
+// tombstones returns the tombstones to be changed, cloned first when a snapshot or a merge holds them, i.mu must
+// be held
+func (i *Index) tombstones() *roaring.Bitmap {
+	if i.deletedShared {
+		i.deleted = i.deleted.Clone()
+		i.deletedShared = false
+	}
+	return i.deleted
+}
+
+// locate finds the segment holding the live version of the doc, i.mu must be held
+func (i *Index) locate(key string) (*Segment, uint32, bool) {
+	if inDocID, ok := i.buffer.docIDExternalToInternal[key]; ok {
+		return i.buffer, inDocID, true
+	}
+
+	for j := len(i.segments) - 1; j >= 0; j-- {
+		inDocID, ok := i.segments[j].docIDExternalToInternal[key]
+		if ok && !i.deleted.Contains(inDocID) {
+			return i.segments[j], inDocID, true
+		}
+	}
+	return nil, 0, false
+}
+
+// newSegment creates an empty segment whose internal doc ids start from `base`, all the mapping fields are
+// registered so querying a field the segment holds no value for returns nothing rather than an error.
+func (i *Index) newSegment(base uint32) *Segment {
+	seg := NewSegment(int32(i.policy.FlushSize))
+	seg.docIdInc = base
+	for _, field := range i.mapping.Fields() {
+		seg.fieldID(field)
+		fm, _ := i.mapping.Field(field)
+		if fm.Type == IndexTypeText {
+			seg.SetAnalyzer(field, fm.Analyzer) // checked when the mapping was parsed
+		}
+		if opts := fm.stringOptions(); opts != 0 {
+			seg.SetStringOptions(field, opts)
+		}
+	}
+	return seg
 }
 
 // Query 查询满足条件的数据
 //
 //	TODO: 阐述查询语法
 func (i *Index) Query(query string, opts ...OptionFunc) ([]interface{}, error) {
-	res, err := DoQuery(query, i.index)
+	return i.QueryWithParams(query, nil, opts...)
+}
+
+// QueryWithParams 参数化查询，查询中的占位符 `:name` `?` 使用 params 中的值，见 Params
+//
+//	docs, err := idx.QueryWithParams(`Age >= :minAge && in_array(City, :cities)`, index.Params{"minAge": 18, "cities": []string{"深圳"}})
+func (i *Index) QueryWithParams(query string, params Params, opts ...OptionFunc) ([]interface{}, error) {
+	pq, err := i.parse(query)
+	if err != nil {
+		return nil, err
+	}
+	snap := i.snap.Load()
+	res, err := snap.run(pq, params)
 	if err != nil {
 		return nil, err
 	}
 
-	return i.GetDocs(res.ExternalDocIDs, opts...)
+	hits, err := snap.hits(res.ExternalDocIDs, nil, opts...)
+	return hitDocs(hits), err
+}
+
+// Hit is a doc matched by a query along with its relevance score
+type Hit struct {
+	Key   string
+	Score float64 // BM25 relevance of the `match()` conditions, 0 if the query has none
+	Doc   interface{}
+}
+
+// Search 查询满足条件的数据，并返回文档的相关性得分。结果默认按得分降序排列，得分相同时按文档的写入顺序；
+// 指定了 WithOrderBy 或 WithLess 时按照指定的方式排序
+//
+//	hits, err := idx.Search(`match(Desc, "深圳南山") && Age > 20`, index.WithSize(10))
+func (i *Index) Search(query string, opts ...OptionFunc) ([]Hit, error) {
+	pq, err := i.parse(query)
+	if err != nil {
+		return nil, err
+	}
+	snap := i.snap.Load()
+	res, err := snap.run(pq, nil)
+	if err != nil {
+		return nil, err
+	}
+	return snap.scoredHits(res, opts...)
+}
+
+// Count 返回满足条件的文档数，直接取结果 bitmap 的基数，不读取文档也不转换文档的 key
+//
+//	n, err := idx.Count(`Age >= 18 && City == "深圳"`)
+func (i *Index) Count(query string) (uint64, error) {
+	pq, err := i.parse(query)
+	if err != nil {
+		return 0, err
+	}
+	res, err := i.snap.Load().match(pq, nil)
+	if err != nil {
+		return 0, err
+	}
+	return res.internalDocIds.GetCardinality(), nil
+}
+
+// QueryIDs 返回满足条件的文档的 key，不读取文档。结果默认按文档的写入顺序排列，WithFrom、WithSize 分页时只转换当前页的
+// key；WithOrderBy 按索引字段排序。WithFilter、WithLess 需要读取文档，不支持
+//
+//	keys, err := idx.QueryIDs(`Age >= 18`, index.WithFrom(100), index.WithSize(20))
+func (i *Index) QueryIDs(query string, opts ...OptionFunc) ([]string, error) {
+	opt := NewOptions(opts...)
+	if opt.filerFn != nil || opt.lessFn != nil {
+		return nil, errors.New("QueryIDs doesn't read the docs, WithFilter and WithLess are not supported")
+	}
+	pq, err := i.parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := i.snap.Load()
+	if len(opt.orderby) > 0 { // all the keys are needed to sort them
+		res, err := snap.run(pq, nil)
+		if err != nil {
+			return nil, err
+		}
+		hits := make([]Hit, len(res.ExternalDocIDs))
+		for j, key := range res.ExternalDocIDs {
+			hits[j].Key = key
+		}
+		if err := snap.orderHits(hits, opt.orderby); err != nil {
+			return nil, err
+		}
+		start, end, err := opt.page(len(hits))
+		if err != nil {
+			return []string{}, err
+		}
+		keys := make([]string, 0, end-start)
+		for _, hit := range hits[start:end] {
+			keys = append(keys, hit.Key)
+		}
+		return keys, nil
+	}
+
+	res, err := snap.match(pq, nil)
+	if err != nil {
+		return nil, err
+	}
+	docs := res.internalDocIds
+	start, end, err := opt.page(int(docs.GetCardinality()))
+	if err != nil {
+		return []string{}, err
+	}
+
+	// walk the page of the results along with the segments, both are ordered by internal doc id
+	keys := make([]string, 0, end-start)
+	it := docs.Iterator()
+	if start > 0 {
+		first, err := docs.Select(uint32(start))
+		if err != nil {
+			return nil, err
+		}
+		it.AdvanceIfNeeded(first)
+	}
+	segs := snap.segments
+	for len(keys) < end-start && it.HasNext() {
+		inDocID := it.Next()
+		for len(segs) > 0 && !segs[0].fullDocIDBits.Contains(inDocID) {
+			segs = segs[1:]
+		}
+		if len(segs) == 0 {
+			return nil, fmt.Errorf("found an internal docID without an external doc ID mapping: id:%v", inDocID)
+		}
+		keys = append(keys, segs[0].docIDInternalToExternal[inDocID])
+	}
+	return keys, nil
+}
+
+// scoredHits returns the hits of the results along with their relevance scores
+func (snap *snapshot) scoredHits(res *SearchResults, opts ...OptionFunc) ([]Hit, error) {
+	scores := make([]float64, len(res.ExternalDocIDs))
+	for j, key := range res.ExternalDocIDs {
+		if inDocID, ok := snap.locate(key); ok {
+			scores[j] = res.scores[inDocID]
+		}
+	}
+	return snap.hits(res.ExternalDocIDs, scores, opts...)
+}
+
+// run runs a parsed query over the segments of the snapshot, the results hold the external ids of the docs
+func (snap *snapshot) run(pq *parsedQuery, params Params) (*SearchResults, error) {
+	res, err := snap.match(pq, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// segments are ordered by internal doc id, so are the external ids
+	res.ExternalDocIDs = make([]string, 0, res.internalDocIds.GetCardinality())
+	for _, seg := range snap.segments {
+		ids, err := GetExternalIDs(seg, roaring.And(res.internalDocIds, seg.fullDocIDBits))
+		if err != nil {
+			return nil, err
+		}
+		res.ExternalDocIDs = append(res.ExternalDocIDs, ids...)
+	}
+
+	return res, nil
+}
+
+// match runs a parsed query over the segments of the snapshot and unions the results, the internal ids of the
+// live docs matched
+func (snap *snapshot) match(pq *parsedQuery, params Params) (*SearchResults, error) {
+	var err error
+	res := &SearchResults{roaring.New(), nil, nil}
+	ec := newEvalContext(snap.segments, snap.deleted) // one `now()` and the same statistics for all the segments
+	if ec.params, err = pq.bind(params); err != nil {
+		return nil, err
+	}
+	if pq.automata != nil {
+		ec.ctx = withAutomata(ec.ctx, pq.automata)
+	}
+	ec.explain = pq.explain
+	for _, seg := range snap.segments {
+		segRes, err := qeval(pq.expr, ec.onSegment(seg))
+		if err != nil {
+			return nil, err
+		}
+
+		segRes.internalDocIds.And(seg.fullDocIDBits) // `Not` of a segment only covers its own docs
+		res.Or(segRes)
+	}
+	res.internalDocIds.AndNot(snap.deleted)
+	return res, nil
 }
 
 func (i *Index) GetDocs(docIDs []string, opts ...OptionFunc) ([]interface{}, error) {
+	hits, err := i.snap.Load().hits(docIDs, nil, opts...)
+	return hitDocs(hits), err
+}
+
+// hits gets the docs of the keys, then filters, sorts and pages them. `scores` are the scores of the keys, the hits
+// are ordered by descending score if no other order is given, nil when unscored.
+func (snap *snapshot) hits(keys []string, scores []float64, opts ...OptionFunc) ([]Hit, error) {
 	opt := NewOptions(opts...)
 	// from docid to doc object
-	queryResult := make([]interface{}, 0, len(docIDs))
-	for _, did := range docIDs {
-		doc, ok := i.docs[did]
+	hits := make([]Hit, 0, len(keys))
+	for j, key := range keys {
+		doc, ok := snap.docs.Get(key)
 		if !ok {
 			continue
 		}
 
 		if opt.filerFn == nil || !opt.filerFn(doc) {
-			queryResult = append(queryResult, doc)
+			hit := Hit{Key: key, Doc: doc}
+			if scores != nil {
+				hit.Score = scores[j]
+			}
+			hits = append(hits, hit)
 		}
 	}
 
-	// order by less function
-	if opt.lessFn != nil {
-		sort.Slice(queryResult, func(i, j int) bool {
-			return opt.lessFn(queryResult[i], queryResult[j])
+	if len(opt.orderby) > 0 { // order by indexed fields
+		if err := snap.orderHits(hits, opt.orderby); err != nil {
+			return nil, err
+		}
+	} else if opt.lessFn != nil { // order by less function
+		sort.SliceStable(hits, func(i, j int) bool {
+			return opt.lessFn(hits[i].Doc, hits[j].Doc)
+		})
+	} else if scores != nil { // order by relevance
+		sort.SliceStable(hits, func(i, j int) bool {
+			return hits[i].Score > hits[j].Score
 		})
 	}
 
 	// paging results
-	if opt.from != 0 || opt.size != 0 {
-		qrcnt := int32(len(queryResult))
-		if opt.from >= qrcnt {
-			return []interface{}{}, ErrEOF
-		}
+	start, end, err := opt.page(len(hits))
+	if err != nil {
+		return []Hit{}, err
+	}
+	return hits[start:end], nil
+}
 
-		end := opt.from + opt.size
-		if end > qrcnt {
-			end = qrcnt
-		}
-		return queryResult[opt.from:end], nil
+// page returns the bounds of the page of the results, ErrEOF if the page starts after the last result
+func (o *Options) page(total int) (start, end int, err error) {
+	if o.from == 0 && o.size == 0 {
+		return 0, total, nil
+	}
+	if int(o.from) >= total {
+		return 0, 0, ErrEOF
+	}
+	return int(o.from), min(int(o.from)+int(o.size), total), nil
+}
+
+// hitDocs returns the docs of the hits
+func hitDocs(hits []Hit) []interface{} {
+	if hits == nil {
+		return nil
 	}
 
-	return queryResult, nil
+	docs := make([]interface{}, 0, len(hits))
+	for _, hit := range hits {
+		docs = append(docs, hit.Doc)
+	}
+	return docs
 }
 
-func (idx *Index) insertDocs(ids []string, docs []interface{}, preprocFn ...Preprocess) error {
+func (idx *Index) insertDocs(ids []string, docs []interface{}) error {
 	if len(ids) != len(docs) {
 		return fmt.Errorf("length not match between ids and documents")
 	}
@@ -90,42 +584,61 @@ func (idx *Index) insertDocs(ids []string, docs []interface{}, preprocFn ...Prep
 	if err != nil {
 		return err
 	}
+	idx.mapping = mapping
+
+	// the initial documents are bulk indexed into the first sealed segment
+	seg := idx.newSegment(0)
+	idx.segments = append(idx.segments, seg)
+	idx.buffer = seg
 
-	now := time.Now()
-	idx.raw = make([]interface{}, 0, len(ids))
 	docsToInsert := make([]Document, 0, len(docs))
 	for i := 0; i < len(ids); i++ {
-		key := ids[i]
-		doc := docs[i]
-
-		for _, pfn := range preprocFn {
-			if pfn == nil {
-				continue
-			}
-			doc = pfn(doc)
+		document, doc, err := idx.prepareDoc(ids[i], docs[i])
+		if err != nil {
+			return err
 		}
 
 		// save raw documents
-		idx.raw = append(idx.raw, doc)
-		idx.docs[key] = doc
+		idx.docs.Set(ids[i], doc)
+		docsToInsert = append(docsToInsert, document)
+	}
 
-		// parse doc to construct index
-		fields, err := mapping.DocWalking(doc)
-		if err != nil {
-			return fmt.Errorf("doc:%s index failed. err:%s", key, err.Error())
+	err = seg.IndexDocuments(context.TODO(), docsToInsert)
+	seg.seal()
+	idx.buffer = idx.newSegment(seg.docIdInc)
+	return err
+}
+
+// prepareDoc runs the preprocess functions on the doc and parses it to the document to be indexed
+func (idx *Index) prepareDoc(key string, doc interface{}) (Document, interface{}, error) {
+	for _, pfn := range idx.preprocFn {
+		if pfn == nil {
+			continue
 		}
+		doc = pfn(doc)
+	}
 
-		docsToInsert = append(docsToInsert, NewDocument(key, fields, now))
+	// parse doc to construct index
+	fields, err := idx.mapping.DocWalking(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("doc:%s index failed. err:%s", key, err.Error())
 	}
 
-	return idx.index.IndexDocuments(context.TODO(), docsToInsert)
+	return NewDocument(key, fields, time.Now()), doc, nil
+}
+
+// snapshot is an immutable view of the index, queries run on it without locking
+type snapshot struct {
+	docs     *btree.Map[string, interface{}] // external doc id ->  data
+	segments []*Segment                      // sealed segments and a copy of the buffer
+	deleted  *roaring.Bitmap                 // tombstoned internal doc ids
 }
 
 type (
 	Options struct {
 		// sort options, if `orderby` and `lessFn` are both provided. use `orderby` to sort
-		orderby *OrderBy
-		lessFn  Less // function to sort, return by ascending order
+		orderby []*OrderBy // order by several fields, the first one is the primary key
+		lessFn  Less       // function to sort, return by ascending order
 
 		// filter options, query results will be filtered by the given filter function
 		filerFn Filter
@@ -157,8 +670,9 @@ func NewOptions(opts ...OptionFunc) *Options {
 	return o
 }
 
-// WithOrderBy
-func WithOrderBy(orderBy *OrderBy) OptionFunc {
+// WithOrderBy 按照索引字段排序，支持多个字段，每个字段可单独指定升序或降序。排序直接使用索引完成，无需反射读取文档，
+// 对多值字段，升序时取其最小值、降序时取其最大值参与排序，没有值的文档排在最后
+func WithOrderBy(orderBy ...*OrderBy) OptionFunc {
 	return func(o *Options) {
 		o.orderby = orderBy
 	}
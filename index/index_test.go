@@ -1,7 +1,16 @@
 package index_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"math"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bmizerany/assert"
 	"github.com/chirlchen/pans/index"
@@ -44,7 +53,7 @@ var (
 	}
 )
 
-func buildIndex(t *testing.T, keys []string, docs []interface{}, preprocFn index.Preprocess) index.Index {
+func buildIndex(t *testing.T, keys []string, docs []interface{}, preprocFn index.Preprocess) *index.Index {
 	idx, err := index.NewIndex(keys, docs, preprocFn)
 
 	if err != nil {
@@ -126,6 +135,41 @@ func TestIndex_Query(t *testing.T) {
 				query: `in_array(Name.Heights,[]int32{3,4})`,
 			}, want: []interface{}{d1, d3, d5}, wantErr: false,
 		},
+		{
+			name: "order-by-multi-keys",
+			args: args{
+				query: "Age > 12",
+				opts: []index.OptionFunc{
+					index.WithOrderBy(&index.OrderBy{FieldName: "Height"}, &index.OrderBy{FieldName: "Name.First", Ascend: true}),
+				},
+			}, want: []interface{}{d4, d6, d7, d3, d5}, wantErr: false,
+		},
+		{
+			name: "order-by-wins-over-less",
+			args: args{
+				query: "Age >= 22",
+				opts: []index.OptionFunc{
+					index.WithOrderBy(&index.OrderBy{FieldName: "Age"}),
+					index.WithLess(func(a, b interface{}) bool { return a.(Cfg).ID < b.(Cfg).ID }),
+				},
+			}, want: []interface{}{d6, d7, d5, d3, d4}, wantErr: false,
+		},
+		{
+			name: "order-by-multi-valued",
+			args: args{
+				query: "Age > 0",
+				opts: []index.OptionFunc{
+					index.WithOrderBy(&index.OrderBy{FieldName: "Name.Heights"}),
+				},
+			}, want: []interface{}{d7, d6, d4, d5, d3, d1, d2}, wantErr: false,
+		},
+		{
+			name: "order-by-unknown-field",
+			args: args{
+				query: "Age > 0",
+				opts:  []index.OptionFunc{index.WithOrderBy(&index.OrderBy{FieldName: "ID"})},
+			}, want: []interface{}(nil), wantErr: true,
+		},
 	}
 	i := buildIndex(t, keys, docs, func(in interface{}) (got interface{}) {
 		val := in.(Cfg)
@@ -147,6 +191,61 @@ func TestIndex_Query(t *testing.T) {
 	}
 }
 
+func TestIndex_QueryWithParams(t *testing.T) {
+	i := buildIndex(t, keys, docs, nil)
+
+	tests := []struct {
+		name    string
+		query   string
+		params  index.Params
+		want    []interface{}
+		wantErr bool
+	}{
+		{
+			name:   "named",
+			query:  `Age >= :minAge && in_array(Name.Last, :lasts)`,
+			params: index.Params{"minAge": 22, "lasts": []string{"zhu"}},
+			want:   []interface{}{d3, d6},
+		},
+		{name: "positional", query: `Age == ? && Name.Last == ?`, params: index.Args(22, "chu"), want: []interface{}{d4}},
+		{
+			name:   "mixed",
+			query:  `Name.Last == :last && Age >= ? && Height <= ?`,
+			params: func() index.Params { p := index.Args(23, 178); p["last"] = "zhu"; return p }(),
+			want:   []interface{}{d6},
+		},
+		{name: "between", query: `between(Age, ?, ?)`, params: index.Args(uint8(22), 25.0), want: []interface{}{d3, d4, d5}},
+		{name: "in-array-ints", query: `in_array(Age, :ages)`, params: index.Params{"ages": []int{12, 26}}, want: []interface{}{d1, d2, d6, d7}},
+		{name: "reused", query: `Age == :age || Height == :age`, params: index.Params{"age": 178}, want: []interface{}{d4, d6}},
+		{name: "pointer", query: `Name.First == :name`, params: index.Params{"name": &d7.Name.First}, want: []interface{}{d7}},
+		{
+			name:   "no-injection",
+			query:  `Name.First == :name`,
+			params: index.Params{"name": `vicky" || Age > 0 || Name.First == "`},
+			want:   []interface{}{},
+		},
+		{name: "in-string-literal", query: `Name.First == ":name"`, want: []interface{}{}},
+		{name: "unused-params", query: `Age == 12`, params: index.Params{"unused": 1}, want: []interface{}{d1, d2}},
+		{name: "missing", query: `Age == :age`, params: index.Params{"Age": 12}, wantErr: true},
+		{name: "missing-positional", query: `Age == ? || Age == ?`, params: index.Args(12), wantErr: true},
+		{name: "nil", query: `Age == :age`, params: index.Params{"age": nil}, wantErr: true},
+		{name: "slice-comparison", query: `Age == :ages`, params: index.Params{"ages": []int{12}}, wantErr: true},
+		{name: "blank-after-colon", query: `Age == : age`, params: index.Params{"age": 12}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.QueryWithParams(tt.query, tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index.QueryWithParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assert.Equalf(t, tt.want, got, "Index.QueryWithParams() got: %v, want: %v", got, tt.want)
+		})
+	}
+}
+
 func TestIndex1_Query(t *testing.T) {
 	type args struct {
 		query string
@@ -226,3 +325,1191 @@ func TestIndex1_Query(t *testing.T) {
 		})
 	}
 }
+
+func TestIndex_UpsertDelete(t *testing.T) {
+	i := buildIndex(t, keys, []interface{}{d1, d2, d3, d4, d5, d6, d7}, nil)
+
+	d3v2 := Cfg{3, 30, 180, &Name{"victor", "wang", nil}, &emptyStr, nil, nil, []int32{1, 2, 3}}
+	d8 := Cfg{8, 12, 160, &Name{"vivian", "zhu", nil}, nil, nil, nil, nil}
+	if err := i.Upsert("3", d3v2); err != nil {
+		t.Fatalf("Index.Upsert() failed: %v", err)
+	}
+	if err := i.Upsert("8", d8); err != nil {
+		t.Fatalf("Index.Upsert() failed: %v", err)
+	}
+	if err := i.Delete("2", "not-exist"); err != nil {
+		t.Fatalf("Index.Delete() failed: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []interface{}
+	}{
+		{name: "updated-term", query: `Name.Last == "zhu"`, want: []interface{}{d6, d8}},
+		{name: "new-term", query: `Name.Last == "wang"`, want: []interface{}{d3v2}},
+		{name: "updated-range", query: `Age >= 26`, want: []interface{}{d6, d7, d3v2}},
+		{name: "deleted-range", query: `Age == 12`, want: []interface{}{d1, d8}},
+		{name: "like-rebuilt-fst", query: `like(Name.First, "vi.*")`, want: []interface{}{d4, d3v2, d8}},
+		{name: "not-equal", query: `Name.Last != "chu"`, want: []interface{}{d1, d5, d6, d3v2, d8}},
+		{name: "not", query: `!(Age < 26)`, want: []interface{}{d6, d7, d3v2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Index.Query() error = %v", err)
+			}
+
+			assert.Equalf(t, tt.want, got, "Index.Query() got: %v, want: %v", got, tt.want)
+		})
+	}
+}
+
+func TestIndex_UpsertFailed(t *testing.T) {
+	i := buildIndex(t, keys, []interface{}{d1, d2, d3, d4, d5, d6, d7}, nil)
+	d8 := Cfg{8, 12, 160, &Name{"vivian", "zhu", nil}, nil, nil, nil, nil}
+	if err := i.Upsert("8", d8); err != nil {
+		t.Fatalf("Index.Upsert() failed: %v", err)
+	}
+
+	// Age is indexed as an int64, the buffer rejects a float64
+	type FloatAge struct {
+		Age  float64
+		Name *Name
+	}
+	for _, key := range []string{"8", "3"} { // a doc of the buffer, a doc of a sealed segment
+		if err := i.Upsert(key, FloatAge{30.5, &Name{"new", "wang", nil}}); err == nil {
+			t.Fatalf("Index.Upsert(%s) expected an error", key)
+		}
+	}
+
+	for query, want := range map[string][]interface{}{
+		`Age == 12`:           {d1, d2, d8},
+		`Age == 22`:           {d3, d4},
+		`Name.Last == "zhu"`:  {d2, d3, d6, d8},
+		`Name.Last == "wang"`: {},
+	} {
+		got, err := i.Query(query)
+		if err != nil {
+			t.Fatalf("Index.Query() error = %v", err)
+		}
+		assert.Equalf(t, want, got, "Index.Query(%s) got: %v, want: %v", query, got, want)
+	}
+}
+
+func TestIndex_Segments(t *testing.T) {
+	i := buildIndex(t, keys[:1], []interface{}{d1}, nil)
+	i.SetMergePolicy(index.MergePolicy{FlushSize: 2, MergeFactor: 2})
+
+	for j, d := range []interface{}{d2, d3, d4, d5, d6, d7} {
+		if err := i.Upsert(keys[j+1], d); err != nil {
+			t.Fatalf("Index.Upsert() failed: %v", err)
+		}
+	}
+	d5v2 := Cfg{5, 40, 170, &Name{"zhengyu", "zhu", nil}, &emptyStr, nil, nil, nil}
+	if err := i.Upsert("5", d5v2); err != nil { // update a doc of a sealed segment
+		t.Fatalf("Index.Upsert() failed: %v", err)
+	}
+	if err := i.Delete("1", "7"); err != nil { // delete docs of a sealed segment and of the buffer
+		t.Fatalf("Index.Delete() failed: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []interface{}
+	}{
+		{name: "term", query: `Name.Last == "zhu"`, want: []interface{}{d2, d3, d6, d5v2}},
+		{name: "range", query: `Age > 22`, want: []interface{}{d6, d5v2}},
+		{name: "like", query: `like(Name.First, "zhen.*")`, want: []interface{}{d6, d5v2}},
+		{name: "not", query: `!(Age == 12 || Age == 22)`, want: []interface{}{d6, d5v2}},
+		{name: "not-equal", query: `Name.First != "grey"`, want: []interface{}{d3, d4, d6, d5v2}},
+	}
+	run := func(t *testing.T) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := i.Query(tt.query)
+				if err != nil {
+					t.Fatalf("Index.Query() error = %v", err)
+				}
+
+				assert.Equalf(t, tt.want, got, "Index.Query() got: %v, want: %v", got, tt.want)
+			})
+		}
+	}
+
+	t.Run("segments", run)
+	i.Flush()
+	if err := i.ForceMerge(); err != nil {
+		t.Fatalf("Index.ForceMerge() failed: %v", err)
+	}
+	t.Run("merged", run)
+}
+
+func TestIndex_MergeConverges(t *testing.T) {
+	i := buildIndex(t, keys[:1], []interface{}{d1}, nil)
+	i.SetMergePolicy(index.MergePolicy{FlushSize: 100, MergeFactor: 2})
+
+	// a segment sealed while the running merge, done, still holds the merge lock: the merge it starts gives up
+	// on the lock, the segment must be merged all the same
+	var once sync.Once
+	i.SetMergesDoneHook(func() {
+		once.Do(func() {
+			if err := i.Upsert("9", d2); err != nil {
+				t.Errorf("Index.Upsert() failed: %v", err)
+			}
+			i.Flush()
+			time.Sleep(20 * time.Millisecond) // the merge the flush started gives up on the lock meanwhile
+		})
+	})
+
+	if err := i.Upsert("8", d3); err != nil {
+		t.Fatalf("Index.Upsert() failed: %v", err)
+	}
+	i.Flush()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for n, merged := i.MergedSegments(); n != 1 || !merged; n, merged = i.MergedSegments() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d segments left unmerged", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	got, err := i.Query(`Age >= 0`)
+	if err != nil {
+		t.Fatalf("Index.Query() error = %v", err)
+	}
+	assert.Equalf(t, 3, len(got), "Index.Query() got %d docs, want 3", len(got))
+}
+
+func TestIndex_Concurrent(t *testing.T) {
+	i := buildIndex(t, keys, docs, nil)
+	i.SetMergePolicy(index.MergePolicy{FlushSize: 4, MergeFactor: 2})
+
+	var wg sync.WaitGroup
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				got, err := i.Query(`like(Name.First, "vic.*") || Name.Last == "chen"`)
+				if err != nil {
+					t.Errorf("Index.Query() error = %v", err)
+					return
+				}
+				if len(got) != 4 { // every snapshot holds d1, d3, d4, d5 once
+					t.Errorf("Index.Query() got %d docs, want 4", len(got))
+					return
+				}
+			}
+		}()
+	}
+
+	for j := 0; j < 100; j++ {
+		key := fmt.Sprintf("new-%d", j)
+		if err := i.Upsert(key, Cfg{ID: 100 + j, Age: j, Name: &Name{"new", "zhu", nil}}); err != nil {
+			t.Fatalf("Index.Upsert() failed: %v", err)
+		}
+		if err := i.Upsert("3", d3); err != nil {
+			t.Fatalf("Index.Upsert() failed: %v", err)
+		}
+		if j%2 == 0 {
+			if err := i.Delete(key); err != nil {
+				t.Fatalf("Index.Delete() failed: %v", err)
+			}
+		}
+	}
+	wg.Wait()
+
+	got, err := i.Query(`Name.First == "new"`)
+	if err != nil {
+		t.Fatalf("Index.Query() error = %v", err)
+	}
+	assert.Equalf(t, 50, len(got), "Index.Query() got %d docs, want 50", len(got))
+}
+
+func TestIndex_Prepare(t *testing.T) {
+	i := buildIndex(t, keys, docs, nil)
+	i.SetMergePolicy(index.MergePolicy{FlushSize: 2, MergeFactor: 2})
+
+	for _, query := range []string{
+		`Nope == 1`,
+		`Age > 1 && like(Nope, "a.*")`,
+		`!Name.Nope`,
+		`Age ==`,
+		`Name.First.Up() == 1`,
+	} {
+		if _, err := i.Prepare(query); err == nil {
+			t.Errorf("Index.Prepare(%s) expected an error", query)
+		}
+	}
+
+	pq, err := i.Prepare(`Age >= :minAge && (like(Name.First, "vic.*") || fuzzy(Name.First, "lukcy"))`)
+	if err != nil {
+		t.Fatalf("Index.Prepare() error = %v", err)
+	}
+	for _, tt := range []struct {
+		minAge int
+		want   []interface{}
+	}{
+		{minAge: 22, want: []interface{}{d3, d4, d7}},
+		{minAge: 26, want: []interface{}{d7}},
+		{minAge: 27, want: []interface{}{}},
+	} {
+		got, err := pq.QueryWithParams(index.Params{"minAge": tt.minAge})
+		if err != nil {
+			t.Fatalf("PreparedQuery.QueryWithParams() error = %v", err)
+		}
+		assert.Equalf(t, tt.want, got, "PreparedQuery.QueryWithParams(%d) got: %v", tt.minAge, got)
+	}
+	if _, err := pq.Query(); err == nil {
+		t.Errorf("PreparedQuery.Query() expected an error for the missing parameter")
+	}
+
+	// executions see the latest docs
+	d8 := Cfg{8, 30, 160, &Name{"vicente", "li", nil}, nil, nil, nil, nil}
+	if err := i.Upsert("8", d8); err != nil {
+		t.Fatalf("Index.Upsert() error = %v", err)
+	}
+	var wg sync.WaitGroup
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				got, err := pq.QueryWithParams(index.Params{"minAge": 26})
+				if err != nil {
+					t.Errorf("PreparedQuery.QueryWithParams() error = %v", err)
+					return
+				}
+				if len(got) != 2 {
+					t.Errorf("PreparedQuery.QueryWithParams() got: %v, want d7 and d8", got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+type Feature struct {
+	Name    string `index:"on"`
+	Enabled bool   `index:"on"`
+	Rollout struct {
+		Gray bool `index:"on"`
+	}
+}
+
+func TestIndex_QueryBool(t *testing.T) {
+	f1 := Feature{Name: "search", Enabled: true}
+	f2 := Feature{Name: "login", Enabled: false}
+	f3 := Feature{Name: "pay", Enabled: true}
+	f3.Rollout.Gray = true
+	i := buildIndex(t, []string{"1", "2", "3"}, []interface{}{f1, f2, f3}, nil)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    []interface{}
+		wantErr bool
+	}{
+		{name: "eq-true", query: `Enabled == true`, want: []interface{}{f1, f3}},
+		{name: "eq-false", query: `Enabled == false`, want: []interface{}{f2}},
+		{name: "neq", query: `Enabled != true`, want: []interface{}{f2}},
+		{name: "bare", query: `Enabled`, want: []interface{}{f1, f3}},
+		{name: "not", query: `!Enabled`, want: []interface{}{f2}},
+		{name: "bare-selector", query: `Enabled && !Rollout.Gray`, want: []interface{}{f1}},
+		{name: "in-array", query: `in_array(Rollout.Gray, []bool{false})`, want: []interface{}{f1, f2}},
+		{name: "range-err", query: `Enabled > true`, wantErr: true},
+		{name: "bare-string-err", query: `Name`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.Query(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index.Query() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equalf(t, tt.want, got, "Index.Query() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type Metric struct {
+	Name   string    `index:"on"`
+	Score  float64   `index:"on"`
+	Ratio  float32   `index:"on"`
+	Ratios []float64 `index:"on"`
+}
+
+func TestIndex_QueryFloat(t *testing.T) {
+	m1 := Metric{"a", 0.5, 0.5, []float64{0.1, 0.2}}
+	m2 := Metric{"b", 0.75, 1, []float64{0.2, 0.3}}
+	m3 := Metric{"c", 0.9, 1.5, nil}
+	m4 := Metric{"d", math.NaN(), 2, []float64{math.NaN()}}
+	i := buildIndex(t, []string{"1", "2", "3", "4"}, []interface{}{m1, m2, m3, m4}, nil)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []interface{}
+	}{
+		{name: "ge", query: `Score >= 0.75`, want: []interface{}{m2, m3}},
+		{name: "lt", query: `Score < 0.75`, want: []interface{}{m1}},
+		{name: "float32", query: `Ratio > 0.9 && Ratio <= 1.5`, want: []interface{}{m2, m3}},
+		{name: "int-literal", query: `Ratio == 1 || Score > 0`, want: []interface{}{m1, m2, m3}},
+		{name: "in-array", query: `in_array(Ratio, []float64{0.5, 1.0})`, want: []interface{}{m1, m2}},
+		{name: "slice", query: `Ratios == 0.2`, want: []interface{}{m1, m2}},
+		{name: "nan-not-equal", query: `Score != 0.5`, want: []interface{}{m2, m3, m4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Index.Query() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Index.Query() got: %v, want: %v", got, tt.want)
+			}
+			for j := range got {
+				assert.Equalf(t, tt.want[j].(Metric).Name, got[j].(Metric).Name, "Index.Query() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndex_QueryTermDic(t *testing.T) {
+	i := buildIndex(t, keys, docs, nil)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    []interface{}
+		wantErr bool
+	}{
+		{name: "prefix", query: `prefix(Name.First, "vic")`, want: []interface{}{d3, d4}},
+		{name: "prefix-empty", query: `prefix(Name.Last, "")`, want: []interface{}{d1, d2, d3, d4, d5, d6, d7}},
+		{name: "prefix-none", query: `prefix(Name.First, "x")`, want: []interface{}{}},
+		{name: "wildcard", query: `wildcard(Name.First, "v?ck*")`, want: []interface{}{d3, d4}},
+		{name: "wildcard-inner", query: `wildcard(Name.First, "*u*")`, want: []interface{}{d5, d7}},
+		{name: "wildcard-quoted", query: `wildcard(Name.Last, "ch.")`, want: []interface{}{}},
+		{name: "wildcard-slice", query: `wildcard(Content, "d?f")`, want: []interface{}{d1, d2, d3, d5, d6, d7}},
+		{name: "fuzzy-transposition", query: `fuzzy(Name.First, "vikcy")`, want: []interface{}{d4}},
+		{name: "fuzzy-2", query: `fuzzy(Name.First, "vikcy", 2)`, want: []interface{}{d3, d4}},
+		{name: "fuzzy-0", query: `fuzzy(Name.First, "grey", 0) && prefix(Name.Last, "zh")`, want: []interface{}{d2}},
+		{name: "fuzzy-too-far", query: `fuzzy(Name.First, "vikcy", 3)`, wantErr: true},
+		{name: "not-a-string", query: `prefix(Name.First, 1)`, wantErr: true},
+		{name: "range-field", query: `prefix(Age, "1")`, wantErr: true},
+		{name: "arity", query: `wildcard(Name.First)`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.Query(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index.Query() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assert.Equalf(t, tt.want, got, "Index.Query() got: %v, want: %v", got, tt.want)
+		})
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	i := buildIndex(t, keys, docs, nil)
+	noop := func(args []ast.Expr, seg *index.Segment) (*index.SearchResults, error) {
+		return index.NewQueryBuilder(context.Background(), seg).Or().Run(true)
+	}
+
+	// the built-in functions are registered in funcNameMap as the user ones, they can't be registered again
+	for _, name := range []string{"in_array", "like", "prefix", "wildcard", "fuzzy"} {
+		assert.Tf(t, index.RegisterFunc(name, noop) != nil, "index.RegisterFunc(%q) should fail", name)
+	}
+	got, err := i.Query(`like(Name.First, "vic.*") && prefix(Name.First, "vic")`)
+	if err != nil {
+		t.Fatalf("Index.Query() error = %v", err)
+	}
+	assert.Equal(t, []interface{}{d3, d4}, got)
+
+	if err := index.RegisterFunc("always_empty", noop); err != nil {
+		t.Fatalf("index.RegisterFunc() error = %v", err)
+	}
+	assert.T(t, index.RegisterFunc("always_empty", noop) != nil)
+	got, err = i.Query(`always_empty() || prefix(Name.First, "vic")`)
+	if err != nil {
+		t.Fatalf("Index.Query() error = %v", err)
+	}
+	assert.Equal(t, []interface{}{d3, d4}, got)
+}
+
+type Package struct {
+	Name    string `index:"on"`
+	Version string `index:"on,natural"`
+	Tag     string `index:"on"`
+}
+
+func TestIndex_QueryStringRange(t *testing.T) {
+	p1 := Package{"pans", "1.9.0", "1.9.0"}
+	p2 := Package{"pans", "1.10.0-rc.1", "1.10.0-rc.1"}
+	p3 := Package{"pans", "1.10.0", "1.10.0"}
+	p4 := Package{"vellum", "2.3.0", "2.3.0"}
+	p5 := Package{"roaring", "10.0.1", "10.0.1"}
+	i := buildIndex(t, []string{"1", "2", "3", "4", "5"}, []interface{}{p1, p2, p3, p4, p5}, nil)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    []interface{}
+		wantErr bool
+	}{
+		{name: "lexicographic", query: `Name < "r"`, want: []interface{}{p1, p2, p3}},
+		{name: "lexicographic-gt", query: `Name > "pans"`, want: []interface{}{p4, p5}},
+		{name: "lexicographic-version", query: `Tag >= "1.9.0"`, want: []interface{}{p1, p4, p5}},
+		{name: "natural", query: `Version >= "1.9.0"`, want: []interface{}{p1, p2, p3, p4, p5}},
+		{name: "natural-prerelease", query: `Version < "1.10.0"`, want: []interface{}{p1, p2}},
+		{name: "natural-between", query: `between(Version, "1.10.0", "2.3.0")`, want: []interface{}{p3, p4}},
+		{name: "natural-leading-zeros", query: `Version > "2.03.0"`, want: []interface{}{p5}},
+		{name: "negated", query: `!(Name <= "pans")`, want: []interface{}{p4, p5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.Query(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index.Query() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equalf(t, tt.want, got, "Index.Query() got: %v, want: %v", got, tt.want)
+		})
+	}
+
+	if _, err := index.NewIndex([]string{"1"}, []interface{}{struct {
+		Age int `index:"on,natural"`
+	}{1}}); err == nil {
+		t.Errorf("index.NewIndex() expected an error for a natural order int field")
+	}
+}
+
+type Account struct {
+	Name  string   `index:"on,lowercase"`
+	Email string   `index:"on"`
+	Tags  []string `index:"on,lowercase"`
+}
+
+func TestIndex_QueryCaseInsensitive(t *testing.T) {
+	a1 := Account{"Vicky", "Vicky@Example.com", []string{"Go", "Rust"}}
+	a2 := Account{"VICKI", "vicki@example.com", []string{"go"}}
+	a3 := Account{"lucky", "lucky@example.com", []string{"C++"}}
+	i := buildIndex(t, []string{"1", "2", "3"}, []interface{}{a1, a2, a3}, nil)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    []interface{}
+		wantErr bool
+	}{
+		{name: "equal", query: `Name == "vicky"`, want: []interface{}{a1}},
+		{name: "equal-upper", query: `Name == "VICKY" || Name == "Vicki"`, want: []interface{}{a1, a2}},
+		{name: "not-equal", query: `Name != "LUCKY"`, want: []interface{}{a1, a2}},
+		{name: "in-array-slice", query: `in_array(Tags, []string{"GO", "c++"})`, want: []interface{}{a1, a2, a3}},
+		{name: "like", query: `like(Name, "Vic.*")`, want: []interface{}{a1, a2}},
+		{name: "prefix", query: `prefix(Name, "VIC")`, want: []interface{}{a1, a2}},
+		{name: "wildcard", query: `wildcard(Name, "V?CK*")`, want: []interface{}{a1, a2}},
+		{name: "fuzzy", query: `fuzzy(Name, "LUKCY")`, want: []interface{}{a3}},
+		{name: "range", query: `Name > "M"`, want: []interface{}{a1, a2}},
+		{name: "case-sensitive", query: `Email == "vicky@example.com"`, want: []interface{}{}},
+		{name: "iequals", query: `iequals(Email, "vicky@example.com")`, want: []interface{}{a1}},
+		{name: "iequals-quoted", query: `iequals(Email, "vicky@example.c.m")`, want: []interface{}{}},
+		{name: "ilike", query: `ilike(Email, "VICK.*")`, want: []interface{}{a1, a2}},
+		{name: "ilike-not-a-string", query: `ilike(Email, 1)`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.Query(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index.Query() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assert.Equalf(t, tt.want, got, "Index.Query() got: %v, want: %v", got, tt.want)
+		})
+	}
+}
+
+type Release struct {
+	Name      string     `index:"on"`
+	CreatedAt time.Time  `index:"on"`
+	PublishAt *time.Time `index:"on"`
+}
+
+func TestIndex_QueryTime(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r1 := Release{Name: "a", CreatedAt: jan, PublishAt: &jan}
+	r2 := Release{Name: "b", CreatedAt: jan.Add(36 * time.Hour)}
+	r3 := Release{Name: "c", CreatedAt: now.Add(-time.Hour), PublishAt: &now}
+	r4 := Release{Name: "d"} // zero time is not indexed
+	i := buildIndex(t, []string{"1", "2", "3", "4"}, []interface{}{r1, r2, r3, r4}, nil)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    []interface{}
+		wantErr bool
+	}{
+		{name: "eq", query: `CreatedAt == time("2024-01-01T00:00:00Z")`, want: []interface{}{r1}},
+		{name: "date-only", query: `CreatedAt > time("2024-01-01")`, want: []interface{}{r2, r3}},
+		{name: "zone", query: `CreatedAt >= time("2024-01-02T20:00:00+08:00")`, want: []interface{}{r2, r3}},
+		{name: "now-minus", query: `CreatedAt >= now() - duration("24h")`, want: []interface{}{r3}},
+		{name: "paren", query: `CreatedAt < (time("2024-01-01") + duration("1h") + duration("11h"))`, want: []interface{}{r1}},
+		{name: "between", query: `between(CreatedAt, time("2024-01-01"), time("2024-01-02T12:00:00Z"))`, want: []interface{}{r1, r2}},
+		{name: "between-num-bounds", query: fmt.Sprintf(`between(CreatedAt, %d, %d)`, jan.UnixNano(), jan.Add(day).UnixNano()), want: []interface{}{r1}},
+		{name: "pointer", query: `PublishAt <= now()`, want: []interface{}{r1, r3}},
+		{name: "neq", query: `CreatedAt != time("2024-01-01")`, want: []interface{}{r2, r3, r4}},
+		{name: "bad-time", query: `CreatedAt > time("yesterday")`, wantErr: true},
+		{name: "bad-duration", query: `CreatedAt > now() - duration(1)`, wantErr: true},
+		{name: "time-plus-time", query: `CreatedAt > now() + now()`, wantErr: true},
+		{name: "between-arity", query: `between(CreatedAt, now())`, wantErr: true},
+		{name: "far-future", query: `CreatedAt < time("9999-12-31")`, wantErr: true},
+		{name: "far-past", query: `between(CreatedAt, time("1600-01-01"), now())`, wantErr: true},
+		{name: "out-of-range-sum", query: `CreatedAt < time("2262-01-01") + duration("8760h")`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.Query(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index.Query() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equalf(t, tt.want, got, "Index.Query() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+
+	// the time index holds unix nano timestamps, years 1678 to 2262
+	sentinel := time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	if err := i.Upsert("5", Release{Name: "e", CreatedAt: sentinel}); err == nil {
+		t.Errorf("Index.Upsert() of a time out of range expected an error")
+	}
+	if _, err := i.QueryWithParams(`CreatedAt < :t`, index.Params{"t": sentinel}); err == nil {
+		t.Errorf("Index.QueryWithParams() of a time out of range expected an error")
+	}
+	if _, err := index.NewIndex([]string{"1"}, []interface{}{Release{Name: "a", CreatedAt: sentinel}}); err == nil {
+		t.Errorf("index.NewIndex() of a time out of range expected an error")
+	}
+	got, err := i.Query(`Name == "e"`)
+	if err != nil {
+		t.Fatalf("Index.Query() error = %v", err)
+	}
+	assert.Equal(t, []interface{}{}, got)
+}
+
+type Level uint8
+
+type Counter struct {
+	Name   string   `index:"on"`
+	Total  int64    `index:"on"`
+	Level  Level    `index:"on"`
+	Big    uint64   `index:"on"`
+	Shards []uint64 `index:"on"`
+}
+
+func TestIndex_QueryIntegerKinds(t *testing.T) {
+	c1 := Counter{"a", 1 << 40, 1, 1, []uint64{1, math.MaxUint64}}
+	c2 := Counter{"b", -3, 2, math.MaxInt64 + 1, nil}
+	c3 := Counter{"c", 0, 255, math.MaxUint64, []uint64{2}}
+	i := buildIndex(t, []string{"1", "2", "3"}, []interface{}{c1, c2, c3}, nil)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []interface{}
+	}{
+		{name: "int64", query: `Total > 1099511627775`, want: []interface{}{c1}},
+		{name: "int64-lt", query: `Total < 1`, want: []interface{}{c2, c3}},
+		{name: "named-uint8", query: `Level >= 2`, want: []interface{}{c2, c3}},
+		{name: "uint64-above-int64", query: `Big > 9223372036854775807`, want: []interface{}{c2, c3}},
+		{name: "uint64-max", query: `Big == 18446744073709551615`, want: []interface{}{c3}},
+		{name: "uint64-ordering", query: `Big < 9223372036854775808`, want: []interface{}{c1}},
+		{name: "uint64-float", query: `Big >= 1.5`, want: []interface{}{c2, c3}},
+		{name: "uint64-slice", query: `in_array(Shards, []uint64{18446744073709551615, 2})`, want: []interface{}{c1, c3}},
+		{name: "int64-above-range", query: `Total < 9223372036854775808`, want: []interface{}{c1, c2, c3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Index.Query() error = %v", err)
+			}
+			assert.Equalf(t, tt.want, got, "Index.Query() got: %v, want: %v", got, tt.want)
+		})
+	}
+
+	got, err := i.Query(`Big > 0`, index.WithOrderBy(&index.OrderBy{FieldName: "Big", Ascend: false}))
+	if err != nil {
+		t.Fatalf("Index.Query() error = %v", err)
+	}
+	assert.Equalf(t, []interface{}{c3, c2, c1}, got, "Index.Query() order by unsigned field")
+}
+
+type Reading struct {
+	Sensor  string        `index:"on"`
+	Temp    int           `index:"on"`
+	Delta   float64       `index:"on"`
+	Offsets []int         `index:"on"`
+	Window  time.Duration `index:"on"`
+}
+
+func TestIndex_QueryLiterals(t *testing.T) {
+	r1 := Reading{"a", -10, -0.5, []int{-1, 2}, time.Hour}
+	r2 := Reading{"b", -5, 0.25, []int{3}, 24 * time.Hour}
+	r3 := Reading{"c", 1024, 1.5, []int{-3}, 7 * 24 * time.Hour}
+	i := buildIndex(t, []string{"1", "2", "3"}, []interface{}{r1, r2, r3}, nil)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    []interface{}
+		wantErr bool
+	}{
+		{name: "negative", query: `Temp > -10`, want: []interface{}{r2, r3}},
+		{name: "negative-float", query: `Delta <= -0.5`, want: []interface{}{r1}},
+		{name: "negative-paren", query: `Temp == -(5)`, want: []interface{}{r2}},
+		{name: "plus", query: `Temp == +1024`, want: []interface{}{r3}},
+		{name: "in-array-negative", query: `in_array(Offsets, []int{-1, -3})`, want: []interface{}{r1, r3}},
+		{name: "between-negative", query: `between(Temp, -10, -5)`, want: []interface{}{r1, r2}},
+		{name: "hex", query: `Temp == 0x400`, want: []interface{}{r3}},
+		{name: "octal-binary-underscore", query: `Temp == 0o2000 && Temp == 0b100_0000_0000 && Temp == 1_024`, want: []interface{}{r3}},
+		{name: "shift", query: `Temp == 1<<10`, want: []interface{}{r3}},
+		{name: "arithmetic", query: `Temp >= 2*-3 + 1`, want: []interface{}{r2, r3}},
+		{name: "mixed-float", query: `Delta > 1/4.0`, want: []interface{}{r3}},
+		{name: "duration-seconds", query: `Window >= 60*60*24*1000000000`, want: []interface{}{r2, r3}},
+		{name: "duration", query: `Window > duration("48h") + -duration("1h")`, want: []interface{}{r3}},
+		{name: "overflow", query: `Temp < 1<<63`, wantErr: true},
+		{name: "division-by-zero", query: `Temp < 1/0`, wantErr: true},
+		{name: "negative-string", query: `Sensor == -"a"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.Query(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index.Query() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assert.Equalf(t, tt.want, got, "Index.Query() got: %v, want: %v", got, tt.want)
+		})
+	}
+}
+
+func TestIndex_MappingErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  interface{}
+	}{
+		{name: "unknown-tag", doc: struct {
+			Age int `index:"yes"`
+		}{1}},
+		{name: "complex", doc: struct {
+			C complex64 `index:"on"`
+		}{1}},
+		{name: "map", doc: struct {
+			M map[string]int `index:"on"`
+		}{nil}},
+		{name: "bool-slice", doc: struct {
+			B []bool `index:"on"`
+		}{[]bool{true}}},
+		{name: "lowercase-int", doc: struct {
+			Age int `index:"on,lowercase"`
+		}{1}},
+		{name: "lowercase-text", doc: struct {
+			Desc string `index:"text,lowercase"`
+		}{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := index.NewIndex([]string{"1"}, []interface{}{tt.doc}); err == nil {
+				t.Errorf("index.NewIndex() expected an error for a field that can't be indexed")
+			}
+		})
+	}
+}
+
+type Article struct {
+	Title string   `index:"text"`
+	Body  string   `index:"text,analyzer=english"`
+	Tags  []string `index:"text,analyzer=whitespace"`
+	Slug  string   `index:"on"`
+}
+
+func TestIndex_QueryText(t *testing.T) {
+	a1 := Article{"The Quick Brown Fox", "A fox jumps over the lazy dog", []string{"Go", "search"}, "quick-fox"}
+	a2 := Article{"Café Society", "Coffee is the best drink of the morning", []string{"coffee"}, "cafe"}
+	a3 := Article{"Lazy Sunday", "The dog sleeps, the fox runs", nil, "lazy-sunday"}
+	i := buildIndex(t, []string{"1", "2", "3"}, []interface{}{a1, a2, a3}, nil)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    []interface{}
+		wantErr bool
+	}{
+		{name: "token", query: `Title == "fox"`, want: []interface{}{a1}},
+		{name: "analyzed-query", query: `Title == "QUICK fox"`, want: []interface{}{a1}},
+		{name: "all-tokens", query: `Title == "quick sunday"`, want: []interface{}{}},
+		{name: "folding", query: `Title == "cafe"`, want: []interface{}{a2}},
+		{name: "stop-words", query: `Body == "the dog"`, want: []interface{}{a1, a3}},
+		{name: "only-stop-words", query: `Body == "the"`, want: []interface{}{}},
+		{name: "whitespace", query: `Tags == "Go"`, want: []interface{}{a1}},
+		{name: "whitespace-case", query: `Tags == "go"`, want: []interface{}{}},
+		{name: "in-array", query: `in_array(Title, []string{"Lazy", "Society"})`, want: []interface{}{a2, a3}},
+		{name: "not", query: `Body != "fox"`, want: []interface{}{a2}},
+		{name: "like-tokens", query: `like(Title, "s.*y")`, want: []interface{}{a2, a3}},
+		{name: "not-analyzed", query: `Slug == "quick"`, want: []interface{}{}},
+		{name: "range-err", query: `Title > "a"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.Query(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index.Query() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equalf(t, tt.want, got, "Index.Query() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+
+	// updated docs are analyzed in the buffer, and still after merging
+	a3v2 := Article{"Busy Monday", "The cat sleeps", nil, "busy-monday"}
+	if err := i.Upsert("3", a3v2); err != nil {
+		t.Fatalf("Index.Upsert() error = %v", err)
+	}
+	for _, merge := range []bool{false, true} {
+		if merge {
+			i.Flush()
+			if err := i.ForceMerge(); err != nil {
+				t.Fatalf("Index.ForceMerge() error = %v", err)
+			}
+		}
+		got, err := i.Query(`Title == "monday" || Body == "SLEEPS"`)
+		if err != nil {
+			t.Fatalf("Index.Query() error = %v", err)
+		}
+		assert.Equalf(t, []interface{}{a3v2}, got, "Index.Query() after upsert, merged:%v", merge)
+	}
+
+	for _, doc := range []interface{}{
+		struct {
+			Title string `index:"text,analyzer=unknown"`
+		}{"a"},
+		struct {
+			Age int `index:"on,analyzer=standard"`
+		}{1},
+		struct {
+			Age int `index:"text"`
+		}{1},
+	} {
+		if _, err := index.NewIndex([]string{"1"}, []interface{}{doc}); err == nil {
+			t.Errorf("index.NewIndex(%#v) expected an error", doc)
+		}
+	}
+}
+
+type Job struct {
+	Title string `index:"text,analyzer=cjk"`
+	Desc  string `index:"text,analyzer=cjk"`
+	City  string `index:"on"`
+}
+
+func TestIndex_QueryMatch(t *testing.T) {
+	j1 := Job{"后端开发工程师", "负责搜索引擎研发，公司位于深圳市南山区科技园", "深圳"}
+	j2 := Job{"产品经理", "负责电商平台产品设计，办公地址在深圳福田", "深圳"}
+	j3 := Job{"前端工程师", "北京海淀中关村，Web前端开发，熟悉React", "北京"}
+	i := buildIndex(t, []string{"1", "2", "3"}, []interface{}{j1, j2, j3}, nil)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    []interface{}
+		wantErr bool
+	}{
+		{name: "words", query: `match(Desc, "深圳南山")`, want: []interface{}{j1}},
+		{name: "one-word", query: `match(Desc, "深圳")`, want: []interface{}{j1, j2}},
+		{name: "title", query: `match(Title, "工程师")`, want: []interface{}{j1, j3}},
+		{name: "latin", query: `match(Desc, "react")`, want: []interface{}{j3}},
+		{name: "bigram", query: `match(Desc, "地址在")`, want: []interface{}{j2}},
+		{name: "combined", query: `match(Title, "开发") && City == "深圳"`, want: []interface{}{j1}},
+		{name: "negated", query: `!match(Desc, "深圳")`, want: []interface{}{j3}},
+		{name: "no-match", query: `match(Desc, "上海")`, want: []interface{}{}},
+		{name: "not-text-field", query: `match(City, "深圳")`, wantErr: true},
+		{name: "arity", query: `match(Desc)`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.Query(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index.Query() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equalf(t, tt.want, got, "Index.Query() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type Post struct {
+	ID   int    `index:"on"`
+	Body string `index:"text,analyzer=english"`
+}
+
+func TestIndex_Search(t *testing.T) {
+	p1 := Post{1, "fox"}
+	p2 := Post{2, "dog"}
+	i := buildIndex(t, []string{"1", "2"}, []interface{}{p1, p2}, nil)
+
+	hits, err := i.Search(`match(Body, "fox")`)
+	if err != nil {
+		t.Fatalf("Index.Search() error = %v", err)
+	}
+	// N=2, df=1: idf = ln(1 + 1.5/1.5), a single term of an average length field scores its idf
+	assert.Equalf(t, []index.Hit{{Key: "1", Score: math.Log(2), Doc: p1}}, hits, "Index.Search() got: %v", hits)
+
+	p3 := Post{3, "the quick brown fox jumps over the lazy dog"}
+	p4 := Post{4, "fox fox fox"}
+	p5 := Post{5, "a fox and a dog"}
+	p6 := Post{6, "fox"} // same as p1, in another segment
+	for _, p := range []Post{p3, p4, p5, p6} {
+		if err := i.Upsert(fmt.Sprint(p.ID), p); err != nil {
+			t.Fatalf("Index.Upsert() error = %v", err)
+		}
+	}
+	i.Flush()
+
+	tests := []struct {
+		name  string
+		query string
+		opts  []index.OptionFunc
+		want  []string
+	}{
+		{name: "tf-and-length", query: `match(Body, "fox")`, want: []string{"4", "1", "6", "5", "3"}},
+		{name: "and-adds-up", query: `match(Body, "fox") && match(Body, "dog")`, want: []string{"5", "3"}},
+		{name: "or", query: `match(Body, "dog") || ID == 4`, want: []string{"2", "5", "3", "4"}},
+		{name: "not-scored", query: `!match(Body, "fox")`, want: []string{"2"}},
+		{name: "paging", query: `match(Body, "fox")`, opts: []index.OptionFunc{index.WithFrom(1), index.WithSize(2)}, want: []string{"1", "6"}},
+		{
+			name:  "order-by",
+			query: `match(Body, "fox")`,
+			opts:  []index.OptionFunc{index.WithOrderBy(&index.OrderBy{FieldName: "ID", Ascend: true})},
+			want:  []string{"1", "3", "4", "5", "6"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, err := i.Search(tt.query, tt.opts...)
+			if err != nil {
+				t.Fatalf("Index.Search() error = %v", err)
+			}
+			got := make([]string, 0, len(hits))
+			for _, hit := range hits {
+				got = append(got, hit.Key)
+			}
+			assert.Equalf(t, tt.want, got, "Index.Search() got: %v", hits)
+		})
+	}
+
+	// the statistics are global: the same doc scores the same in any segment, deleted docs don't count
+	hits, err = i.Search(`match(Body, "fox")`)
+	if err != nil {
+		t.Fatalf("Index.Search() error = %v", err)
+	}
+	if hits[1].Score != hits[2].Score || hits[1].Score <= hits[3].Score || hits[3].Score <= 0 {
+		t.Errorf("Index.Search() unexpected scores: %v", hits)
+	}
+	before := hits[1].Score
+	if err := i.Delete("2"); err != nil {
+		t.Fatalf("Index.Delete() error = %v", err)
+	}
+	if hits, _ = i.Search(`match(Body, "fox")`); hits[1].Score >= before {
+		t.Errorf("Index.Search() score of a term should drop when a doc without it is deleted, got %v, before %v", hits[1].Score, before)
+	}
+
+	if hits, _ = i.Search(`ID >= 1`); hits[0].Score != 0 {
+		t.Errorf("Index.Search() without match() should not score, got: %v", hits)
+	}
+}
+
+type Ticket struct {
+	Title    string    `index:"text"`
+	Status   string    `index:"on"`
+	Priority int       `index:"on"`
+	Open     bool      `index:"on"`
+	Created  time.Time `index:"on"`
+}
+
+func TestIndex_Validate(t *testing.T) {
+	t1 := Ticket{"Login fails on Safari", "new", 1, true, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	i := buildIndex(t, []string{"1"}, []interface{}{t1}, nil)
+
+	tests := []struct {
+		name   string
+		query  string
+		offset int
+		end    int
+		msg    string
+	}{
+		{name: "ok", query: `Priority >= 1 && Open && match(Title, "safari") && between(Created, time("2024-01-01"), now())`},
+		{name: "ok-params", query: `Priority >= :p && in_array(Status, :statuses) && fuzzy(Status, ?, 1)`},
+		{name: "ok-time-number", query: `Created > 1704067200000000000 && Priority < 1 + 2`},
+		{name: "unknown-field", query: `Priority > 1 && Nope == 1`, offset: 16, end: 20, msg: "field Nope is not indexed"},
+		{name: "placeholder-field", query: `:field == 1`, offset: 0, end: 6, msg: "placeholder :field can only be a value, not a field"},
+		{name: "bool-range", query: `Open > true`, offset: 5, end: 6, msg: "operator > is not supported by the bool field Open"},
+		{name: "text-range", query: `Title <= "x"`, offset: 6, end: 8, msg: "operator <= is not supported by the text field Title"},
+		{name: "type-mismatch", query: `Priority == "1"`, offset: 12, end: 15, msg: `number field Priority can't be compared with "1"`},
+		{name: "not-a-condition", query: `Open && Status`, offset: 8, end: 14, msg: "string field Status is not a condition, compare it with a value"},
+		{name: "operator", query: `Priority + 1`, offset: 9, end: 10, msg: "operator + is not supported, expected a comparison, && or ||"},
+		{name: "arity", query: `Open && like(Status)`, offset: 8, end: 20, msg: "func like: expected 2 arguments, got 1"},
+		{name: "arity-range", query: `fuzzy(Status, "a", 1, 2)`, offset: 0, end: 24, msg: "func fuzzy: expected 2 to 3 arguments, got 4"},
+		{name: "func-field-kind", query: `match(Status, "new")`, offset: 6, end: 12, msg: "func match doesn't support the string field Status"},
+		{name: "func-arg", query: `prefix(Status, 1)`, offset: 15, end: 16, msg: "func prefix: expected a string, got 1"},
+		{name: "bad-regex", query: `like(Status, "(new")`, offset: 13, end: 19},
+		{name: "fuzziness", query: `fuzzy(Status, "new", 3)`, offset: 21, end: 22, msg: "func fuzzy: fuzziness must be an integer between 0 and 2"},
+		{name: "in-array-elem", query: `in_array(Priority, []int{1, "2"})`, offset: 28, end: 31, msg: `number field Priority can't be compared with "2"`},
+		{name: "unknown-func", query: `nope(Status)`, offset: 0, end: 4, msg: "unknown function nope"},
+		{name: "multiline", query: "Open &&\n  Nope == 1", offset: 10, end: 14, msg: "field Nope is not indexed"},
+		{name: "bad-literal", query: `Created > time("yesterday")`, offset: 10, end: 27},
+		{name: "time-out-of-range", query: `Created < time("9999-12-31")`, offset: 10, end: 28},
+		{name: "syntax", query: `Priority ==`, offset: 11, end: 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := i.Validate(tt.query)
+			if tt.offset == 0 && tt.end == 0 {
+				if err != nil {
+					t.Errorf("Index.Validate() error = %v", err)
+				}
+				return
+			}
+
+			var qe *index.QueryError
+			if !errors.As(err, &qe) {
+				t.Fatalf("Index.Validate() error = %v, want a *index.QueryError", err)
+			}
+			assert.Equalf(t, [2]int{tt.offset, tt.end}, [2]int{qe.Offset, qe.End}, "Index.Validate() error = %v", err)
+			if tt.msg != "" {
+				assert.Equalf(t, tt.msg, qe.Msg, "Index.Validate() error = %v", err)
+			}
+			if _, err := i.Query(tt.query); err == nil || err.Error() != qe.Error() {
+				t.Errorf("Index.Query() error = %v, want %v", err, qe)
+			}
+		})
+	}
+
+	err := i.Validate("Open &&\n  Nope == 1")
+	assert.Equal(t, "2:3: field Nope is not indexed", err.Error())
+}
+
+func TestIndex_Explain(t *testing.T) {
+	i := buildIndex(t, keys, docs, nil)
+
+	exp, err := i.Explain(`Age >= 22 && (like(Name.First, "vic.*") || prefix(Name.First, "zhen")) && !Name.Last == "chu"`)
+	if err == nil {
+		t.Fatalf("Index.Explain() expected an error for an invalid query, got %v", exp)
+	}
+
+	query := `Age >= 22 && (like(Name.First, "vic.*") || prefix(Name.First, "zhen")) && !(Name.Last == "chu")`
+	exp, err = i.Explain(query)
+	if err != nil {
+		t.Fatalf("Index.Explain() error = %v", err)
+	}
+	assert.Equal(t, query, exp.Query)
+	assert.Equal(t, 3, exp.Docs) // d3, d5, d6
+
+	and := exp.Plan // the conditions of the chain of && are planned together
+	assert.Equal(t, "and", and.Kind)
+	assert.Equal(t, uint64(3), and.Docs)
+	assert.Equal(t, 3, len(and.Children))
+
+	age, or, not := and.Children[0], and.Children[1], and.Children[2]
+	assert.Equal(t, index.ExplainNode{Kind: "range", Expr: "Age >= 22", Field: "Age", Index: "range", Docs: 5, Estimate: 3},
+		index.ExplainNode{Kind: age.Kind, Expr: age.Expr, Field: age.Field, Index: age.Index, Docs: age.Docs, Estimate: age.Estimate})
+
+	assert.Equal(t, "or", or.Kind)
+	assert.Equal(t, uint64(4), or.Docs)
+	assert.Equal(t, `like(Name.First, "vic.*") || prefix(Name.First, "zhen")`, or.Expr)
+	like, prefix := or.Children[0], or.Children[1]
+	assert.Equal(t, "regex", like.Kind)
+	assert.Equal(t, "term", like.Index)
+	assert.Equal(t, []string{"vicki", "vicky"}, like.Terms)
+	assert.Equal(t, 2, like.TermCount)
+	assert.Equal(t, "prefix", prefix.Kind)
+	assert.Equal(t, []string{"zhengyu", "zhenhai"}, prefix.Terms)
+
+	assert.Equal(t, "and_not", not.Kind)
+	assert.Equal(t, uint64(1), not.Docs) // vicky chu removed
+	assert.Equal(t, uint64(2), not.Estimate)
+	assert.Equal(t, uint64(2), not.Children[0].Docs)
+	assert.Equal(t, "Name.Last", not.Children[0].Field)
+
+	text := exp.String()
+	for _, line := range []string{
+		"and docs=3",
+		"├─ range Age >= 22 [range] docs=5 estimate=3",
+		"├─ or docs=4 estimate=7",
+		`│  ├─ regex like(Name.First, "vic.*") [term] docs=2 elapsed=`,
+		"terms=2 vicki,vicky",
+		`└─ and_not docs=1 estimate=2`,
+		`   └─ term Name.Last == "chu" [term] docs=2 elapsed=`,
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("Explanation.String() = %s, want it to contain %q", text, line)
+		}
+	}
+
+	data, err := json.Marshal(exp)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var decoded index.Explanation
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	assert.Equal(t, []string{"zhengyu", "zhenhai"}, decoded.Plan.Children[1].Children[1].Terms)
+	assert.Equal(t, exp.Plan.Elapsed, decoded.Plan.Elapsed)
+}
+
+func TestIndex_QueryPlanner(t *testing.T) {
+	i := buildIndex(t, keys, docs, nil)
+	i.SetMergePolicy(index.MergePolicy{FlushSize: 2, MergeFactor: 8})
+	d8 := Cfg{8, 30, 180, &Name{"vic", "chen", nil}, nil, nil, nil, nil}
+	if err := i.Upsert("8", d8); err != nil {
+		t.Fatalf("Index.Upsert() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []interface{}
+	}{
+		{name: "selective-last", query: `Age >= 12 && Height >= 170 && Name.First == "vicky"`, want: []interface{}{d4}},
+		{name: "nested", query: `(Age >= 22 && (Height == 175 && Name.Last == "zhu")) && !(Name.First == "zhenhai")`, want: []interface{}{d3}},
+		{name: "and-not", query: `Name.Last == "chen" && !(Age == 12)`, want: []interface{}{d5, d8}},
+		{name: "and-not-parens", query: `!(Age == 12) && (Name.Last == "chen")`, want: []interface{}{d5, d8}},
+		{name: "not-only", query: `!(Age == 12) && !(Height == 175) && !(Name.Last == "chen")`, want: []interface{}{d4, d6}},
+		{name: "not-not", query: `Age == 26 && !!(Name.Last == "chu")`, want: []interface{}{d7}},
+		{name: "empty", query: `Name.Last == "nope" && like(Name.First, "vic.*") && !(Age == 12)`, want: []interface{}{}},
+		{name: "or-inside", query: `(Age == 12 || Age == 30) && !(Name.First == "grey")`, want: []interface{}{d1, d8}},
+		{name: "in-array", query: `in_array(Age, []int{22, 30}) && like(Name.First, "vic.*")`, want: []interface{}{d3, d4, d8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Index.Query() error = %v", err)
+			}
+			assert.Equalf(t, tt.want, got, "Index.Query() got: %v, want: %v", got, tt.want)
+		})
+	}
+
+	calls := 0
+	if err := index.RegisterFunc("count_calls", func(args []ast.Expr, seg *index.Segment) (*index.SearchResults, error) {
+		calls++
+		return index.NewQueryBuilder(context.Background(), seg).Or().Run(true)
+	}); err != nil {
+		t.Fatalf("index.RegisterFunc() error = %v", err)
+	}
+	if _, err := i.Query(`count_calls() && Name.Last == "nope"`); err != nil {
+		t.Fatalf("Index.Query() error = %v", err)
+	}
+	assert.Equalf(t, 0, calls, "the conditions after an empty result should be skipped")
+
+	exp, err := i.Explain(`count_calls() && Name.Last == "nope"`)
+	if err != nil {
+		t.Fatalf("Index.Explain() error = %v", err)
+	}
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, 2, exp.Plan.Children[0].Skipped) // on both segments
+	assert.Equal(t, uint64(0), exp.Plan.Children[1].Estimate)
+}
+
+func TestIndex_SearchAndNot(t *testing.T) {
+	a1 := Article{"The Quick Brown Fox", "A fox jumps over the lazy dog", []string{"Go", "search"}, "quick-fox"}
+	a2 := Article{"Lazy Sunday", "The dog sleeps, the fox runs", nil, "lazy-sunday"}
+	a3 := Article{"Fox News", "The fox", nil, "fox-news"}
+	i := buildIndex(t, []string{"1", "2", "3"}, []interface{}{a1, a2, a3}, nil)
+
+	hits, err := i.Search(`match(Body, "fox") && !(Slug == "lazy-sunday")`)
+	if err != nil {
+		t.Fatalf("Index.Search() error = %v", err)
+	}
+	assert.Equal(t, 2, len(hits))
+	for _, hit := range hits {
+		if hit.Score <= 0 {
+			t.Errorf("Index.Search() got a hit without score: %v", hit)
+		}
+	}
+}
+
+func TestIndex_CountAndQueryIDs(t *testing.T) {
+	i := buildIndex(t, keys, docs, nil)
+	i.SetMergePolicy(index.MergePolicy{FlushSize: 2, MergeFactor: 8})
+	d8 := Cfg{8, 30, 180, &Name{"vic", "chen", nil}, nil, nil, nil, nil}
+	d9 := Cfg{9, 31, 181, &Name{"vivi", "wu", nil}, nil, nil, nil, nil}
+	for key, doc := range map[string]Cfg{"8": d8, "9": d9} {
+		if err := i.Upsert(key, doc); err != nil {
+			t.Fatalf("Index.Upsert() error = %v", err)
+		}
+	}
+	if err := i.Delete("2", "9"); err != nil {
+		t.Fatalf("Index.Delete() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		opts    []index.OptionFunc
+		want    []string
+		wantErr error
+	}{
+		{name: "all", query: `Age >= 12`, want: []string{"1", "3", "4", "5", "6", "7", "8"}},
+		{name: "page", query: `Age >= 12`, opts: []index.OptionFunc{index.WithFrom(2), index.WithSize(3)}, want: []string{"4", "5", "6"}},
+		{name: "page-across-segments", query: `Age >= 12`, opts: []index.OptionFunc{index.WithFrom(5), index.WithSize(10)}, want: []string{"7", "8"}},
+		{name: "eof", query: `Age >= 12`, opts: []index.OptionFunc{index.WithFrom(7), index.WithSize(10)}, want: []string{}, wantErr: index.ErrEOF},
+		{name: "order-by", query: `Age >= 22`, opts: []index.OptionFunc{index.WithOrderBy(&index.OrderBy{FieldName: "Age", Ascend: false}), index.WithSize(2)},
+			want: []string{"8", "6"}},
+		{name: "none", query: `Age > 100`, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.QueryIDs(tt.query, tt.opts...)
+			if err != tt.wantErr {
+				t.Fatalf("Index.QueryIDs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equalf(t, tt.want, got, "Index.QueryIDs() got: %v, want: %v", got, tt.want)
+
+			docs, err := i.Query(tt.query, tt.opts...)
+			if err != tt.wantErr {
+				t.Fatalf("Index.Query() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equalf(t, len(tt.want), len(docs), "Index.QueryIDs() and Index.Query() should return the same page")
+		})
+	}
+
+	for query, want := range map[string]uint64{`Age >= 12`: 7, `Name.Last == "chen"`: 3, `!(Age == 12)`: 6, `Age > 100`: 0} {
+		got, err := i.Count(query)
+		if err != nil {
+			t.Fatalf("Index.Count(%s) error = %v", query, err)
+		}
+		assert.Equalf(t, want, got, "Index.Count(%s)", query)
+	}
+	if _, err := i.Count(`Nope == 1`); err == nil {
+		t.Errorf("Index.Count() expected an error for an unknown field")
+	}
+	if _, err := i.QueryIDs(`Age >= 12`, index.WithFilter(func(a interface{}) bool { return false })); err == nil {
+		t.Errorf("Index.QueryIDs() expected an error for WithFilter")
+	}
+}
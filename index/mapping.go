@@ -3,6 +3,9 @@ package index
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/araddon/qlbridge/value"
 )
@@ -16,11 +19,13 @@ const (
 
 	IndexTypeTerm IndexType = 2 + iota
 	IndexTypeRange
+	IndexTypeText // analyzed string, see Analyzer
 )
 
 var idxName = map[IndexType]string{
 	IndexTypeTerm:    "term",
 	IndexTypeRange:   "range",
+	IndexTypeText:    "text",
 	IndexTypeInvalid: "invalid",
 }
 
@@ -40,24 +45,105 @@ func NewIndexType(name string) IndexType {
 		return IndexTypeRange
 	case "on":
 		return IndexTypeOn
-
+	case "text":
+		return IndexTypeText
 	}
 	return IndexTypeInvalid
 }
 
+var timeType = reflect.TypeOf(time.Time{})
+
 type Mapping struct {
-	m map[string]IndexType
+	m map[string]*FieldMapping
+}
+
+// FieldMapping 字段索引配置，由字段 tag 解析而来：`index:"<type>[,option=value...]"`，如：`index:"text,analyzer=english"`
+type FieldMapping struct {
+	Type      IndexType
+	Analyzer  string // name of the analyzer of a text field, see RegisterAnalyzer
+	Natural   bool   // range comparisons of a string field in natural order, see NaturalOrder
+	Lowercase bool   // a string field is indexed and queried in lower case, see Lowercase
+
+	valueType value.ValueType // type of the values of the field in the first document, for the query validation
+}
+
+// stringOptions returns the options of a string field, see Segment.SetStringOptions
+func (fm *FieldMapping) stringOptions() StringOption {
+	var opts StringOption
+	if fm.Natural {
+		opts |= NaturalOrder
+	}
+	if fm.Lowercase {
+		opts |= Lowercase
+	}
+	return opts
+}
+
+// parseIndexTag parses the `index` tag of a field, unknown types and options are errors
+func parseIndexTag(tag string) (*FieldMapping, error) {
+	parts := strings.Split(tag, ",")
+	fm := &FieldMapping{Type: NewIndexType(strings.TrimSpace(parts[0]))}
+	if fm.Type == IndexTypeInvalid {
+		return nil, fmt.Errorf("unknown index tag:%q", tag)
+	}
+
+	for _, opt := range parts[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		switch key {
+		case "analyzer":
+			if fm.Type != IndexTypeText {
+				return nil, fmt.Errorf("index tag:%q, analyzer is only supported by text fields", tag)
+			}
+			if _, err := GetAnalyzer(val); err != nil {
+				return nil, fmt.Errorf("index tag:%q, %v", tag, err)
+			}
+			fm.Analyzer = val
+		case "natural":
+			if fm.Type != IndexTypeOn && fm.Type != IndexTypeTerm {
+				return nil, fmt.Errorf("index tag:%q, natural is only supported by string fields", tag)
+			}
+			fm.Natural = true
+		case "lowercase":
+			if fm.Type != IndexTypeOn && fm.Type != IndexTypeTerm {
+				return nil, fmt.Errorf("index tag:%q, lowercase is only supported by string fields, text fields use analyzers", tag)
+			}
+			fm.Lowercase = true
+		default:
+			return nil, fmt.Errorf("index tag:%q, unknown option:%q", tag, key)
+		}
+	}
+
+	if fm.Type == IndexTypeText && fm.Analyzer == "" {
+		fm.Analyzer = DefaultAnalyzer
+	}
+	return fm, nil
 }
 
 func NewMappingByDoc(doc interface{}) (*Mapping, error) {
 	mp := &Mapping{
-		m: make(map[string]IndexType),
+		m: make(map[string]*FieldMapping),
 	}
 
 	err := docWalking(mp, doc, "", true, "", nil)
 	return mp, err
 }
 
+// Fields returns the paths of the indexed fields, sorted
+func (mp *Mapping) Fields() []string {
+	fields := make([]string, 0, len(mp.m))
+	for field := range mp.m {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Field returns the mapping of an indexed field
+func (mp *Mapping) Field(field string) (*FieldMapping, bool) {
+	fm, ok := mp.m[field]
+	return fm, ok
+}
+
 func (mp *Mapping) DocWalking(doc interface{}) (map[string]value.Value, error) {
 	fields := make(map[string]value.Value, len(mp.m))
 
@@ -75,18 +161,29 @@ func docWalking(mapping *Mapping, doc interface{}, path FieldPath, mappingInit b
 		doc = fv.GetValue()
 	}
 
+	// only nil values are skipped, zero values such as `false` or `0` are indexed as any other value
 	val := reflect.ValueOf(doc)
-	if val.IsZero() {
-		return nil
-	}
-	typ := val.Type()
-	for {
-		if typ.Kind() != reflect.Pointer {
-			break
+	for val.Kind() == reflect.Pointer {
+		if val.IsNil() {
+			return nil
 		}
 
 		val = val.Elem()
-		typ = val.Type()
+	}
+	typ := val.Type()
+
+	if typ == timeType { // a time is indexed as a range value rather than walked through
+		if _, ok := mapping.m[path.String()]; ok {
+			if t := val.Interface().(time.Time); !t.IsZero() { // the zero time means unset
+				(*outFields)[path.String()] = value.NewTimeValue(t)
+			}
+			return nil
+		}
+		fm, err := checkMapping(mapping, path, idxTag, mappingInit)
+		if fm != nil {
+			fm.valueType = value.TimeType
+		}
+		return err
 	}
 
 	switch typ.Kind() {
@@ -116,35 +213,69 @@ func docWalking(mapping *Mapping, doc interface{}, path FieldPath, mappingInit b
 				return err
 			}
 		}
-	case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Slice, reflect.Array:
-		if it, ok := mapping.m[path.String()]; ok && it != IndexTypeInvalid {
-			switch typ.Kind() {
-			case reflect.Slice, reflect.Array:
-				sval := NewSliceValue(val)
-				if err, ok := sval.(value.ErrorValue); ok {
-					return err.Val()
-				}
-
-				(*outFields)[path.String()] = sval
-			default:
-				(*outFields)[path.String()] = value.NewValue(val.Interface())
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64, reflect.Slice, reflect.Array,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if fm, ok := mapping.m[path.String()]; ok {
+			fval := newLeafValue(val)
+			if err, ok := fval.(value.ErrorValue); ok {
+				return fmt.Errorf("field: `%s` %v", path, err.Val())
 			}
-		} else {
-			checkMapping(mapping, path, idxTag, mappingInit)
+			if fm.Type == IndexTypeText && fval.Type() != value.StringType && fval.Type() != value.StringsType {
+				return fmt.Errorf("field: `%s` text index only supports strings, got %s", path, typ)
+			}
+			if (fm.Natural || fm.Lowercase) && fval.Type() != value.StringType && fval.Type() != value.StringsType {
+				return fmt.Errorf("field: `%s` natural and lowercase options only support strings, got %s", path, typ)
+			}
+
+			(*outFields)[path.String()] = fval
+		} else if fm, err := checkMapping(mapping, path, idxTag, mappingInit); err != nil {
+			return err
+		} else if fm != nil {
+			fm.valueType = newLeafValue(val).Type()
 		}
-	default: // reflect.Chan, reflect.Func, reflect.Map, reflect.Float*
+	default: // reflect.Chan, reflect.Func, reflect.Map, reflect.Complex64, reflect.Complex128
 		if len(idxTag) != 0 {
-			return fmt.Errorf("type `%s` is not support index", typ.Kind())
+			return fmt.Errorf("field: `%s` type `%s` is not support index", path, typ.Kind())
 		}
 	}
 
 	return nil
 }
 
-func checkMapping(mapping *Mapping, path FieldPath, indexTag string, mappingInit bool) {
-	if mappingInit && len(indexTag) != 0 {
-		mapping.m[string(path)] = NewIndexType(indexTag)
+// newLeafValue converts a leaf field to the value it's indexed with. Integers are converted by kind rather than
+// type, so named types such as `type Level int8` are indexed too, and unsigned integers keep their full range.
+func newLeafValue(val reflect.Value) value.Value {
+	switch val.Kind() {
+	case reflect.Bool:
+		return value.NewBoolValue(val.Bool())
+	case reflect.String:
+		return value.NewStringValue(val.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.NewIntValue(val.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return NewUintValue(val.Uint())
+	case reflect.Float32, reflect.Float64:
+		return value.NewNumberValue(val.Float())
+	case reflect.Slice, reflect.Array:
+		return NewSliceValue(val)
+	}
+	return value.NewErrorValue(fmt.Errorf("type:%s not supported index", val.Type()))
+}
+
+// checkMapping registers the field when the mapping is initialized and returns its mapping, an unknown tag is an
+// error rather than a silently unindexed field.
+func checkMapping(mapping *Mapping, path FieldPath, indexTag string, mappingInit bool) (*FieldMapping, error) {
+	if !mappingInit || len(indexTag) == 0 {
+		return nil, nil
+	}
+
+	fm, err := parseIndexTag(indexTag)
+	if err != nil {
+		return nil, fmt.Errorf("field: `%s` %v", path, err)
 	}
+	mapping.m[string(path)] = fm
+	return fm, nil
 }
 
 type FieldPath string
@@ -0,0 +1,189 @@
+package index
+
+import (
+	"math"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// MergePolicy 控制写入缓冲区的落盘（封存）时机以及后台段合并策略
+type MergePolicy struct {
+	FlushSize   int // docs buffered in the mutable segment before it's sealed into an immutable one
+	MergeFactor int // number of adjacent sealed segments of the same size level that get merged into one
+}
+
+// DefaultMergePolicy
+var DefaultMergePolicy = MergePolicy{FlushSize: 256, MergeFactor: 8}
+
+// level returns the size level of a segment, segments with the same level are of similar size
+func (p MergePolicy) level(size uint64) int {
+	flush := math.Max(float64(p.FlushSize), 1)
+	if float64(size) <= flush {
+		return 0
+	}
+
+	return int(math.Log(float64(size)/flush) / math.Log(math.Max(float64(p.MergeFactor), 2)))
+}
+
+// findMerge picks a run of adjacent segments [from, to) to merge. Starting from the newest segments, it looks for
+// `MergeFactor` adjacent segments of the same level, so segments grow like a log-structured merge tree and the
+// cost of rebuilding stays bounded.
+func (p MergePolicy) findMerge(sizes []uint64) (from, to int, ok bool) {
+	if p.MergeFactor < 2 {
+		return 0, 0, false
+	}
+
+	to = len(sizes)
+	for to >= p.MergeFactor {
+		from = to - 1
+		lvl := p.level(sizes[from])
+		for from > 0 && p.level(sizes[from-1]) == lvl && to-from < p.MergeFactor {
+			from--
+		}
+		if to-from == p.MergeFactor {
+			return from, to, true
+		}
+		to = from
+	}
+
+	return 0, 0, false
+}
+
+// mergeSegments merges the given sealed segments into a new one, dropping the deleted docs. Internal doc ids are
+// unique across the segments of an index, so they're kept as is and no remapping is needed.
+func mergeSegments(segs []*Segment, deleted *roaring.Bitmap) (*Segment, error) {
+	docCnt := uint64(0)
+	for _, seg := range segs {
+		docCnt += seg.fullDocIDBits.GetCardinality()
+	}
+
+	merged := NewSegment(int32(docCnt))
+	for _, seg := range segs {
+		merged.mergeFrom(seg, deleted)
+	}
+
+	dirty := make(map[uint32]struct{}, len(merged.fieldsTermDic))
+	for fieldID := range merged.fieldsTermDic {
+		dirty[fieldID] = struct{}{}
+	}
+	return merged, merged.buildTermDics(dirty)
+}
+
+// mergeFrom copies the live docs of src into the segment, the term dictionaries must be rebuilt afterwards.
+func (seg *Segment) mergeFrom(src *Segment, deleted *roaring.Bitmap) {
+	live := roaring.AndNot(src.fullDocIDBits, deleted)
+	seg.fullDocIDBits.Or(live)
+	for iter := live.Iterator(); iter.HasNext(); {
+		inDocID := iter.Next()
+		externalID := src.docIDInternalToExternal[inDocID]
+		seg.docIDInternalToExternal[inDocID] = externalID
+		seg.docIDExternalToInternal[externalID] = inDocID
+	}
+	if src.docIdInc > seg.docIdInc {
+		seg.docIdInc = src.docIdInc
+	}
+
+	for field, srcFieldID := range src.fieldToFieldId {
+		fieldID := seg.fieldID(field)
+		if fa, ok := src.analyzers[srcFieldID]; ok {
+			seg.analyzers[fieldID] = fa
+		}
+		if opts, ok := src.stringOptions[srcFieldID]; ok {
+			seg.stringOptions[fieldID] = opts
+		}
+	}
+
+	for _, srcField := range src.fieldsTermDic {
+		fieldID := seg.fieldID(srcField.FieldName)
+		iField, ok := seg.fieldsTermDic[fieldID]
+		if !ok {
+			iField = NewIndexableField(srcField.FieldName, fieldID)
+			seg.fieldsTermDic[fieldID] = iField
+		}
+
+		for term, srcTermID := range srcField.termToTermID {
+			srcPostings := src.postings[srcTermID]
+			postings := roaring.And(srcPostings.Postings(), live)
+			if postings.IsEmpty() {
+				continue
+			}
+
+			termID, ok := iField.termToTermID[term]
+			if !ok {
+				termID = seg.termIdInc
+				iField.termToTermID[term] = termID
+				seg.termIdInc++
+				seg.postings[termID] = TermPostingList{0, roaring.New()}
+			}
+
+			postingList := seg.postings[termID]
+			postingList.Postings().Or(postings)
+			postingList.TermFrequency += uint32(postings.GetCardinality())
+			seg.postings[termID] = postingList
+
+			if srcFreqs, ok := src.termFreqs[srcTermID]; ok {
+				freqs, ok := seg.termFreqs[termID]
+				if !ok {
+					freqs = make(map[uint32]uint32)
+					seg.termFreqs[termID] = freqs
+				}
+				for inDocID, freq := range srcFreqs {
+					if live.Contains(inDocID) {
+						freqs[inDocID] = freq
+					}
+				}
+			}
+		}
+	}
+
+	for field, srcFieldID := range src.fieldToFieldId {
+		srcLengths, ok := src.fieldLengths[srcFieldID]
+		if !ok {
+			continue
+		}
+
+		fieldID := seg.fieldID(field)
+		lengths, ok := seg.fieldLengths[fieldID]
+		if !ok {
+			lengths = make(map[uint32]uint32)
+			seg.fieldLengths[fieldID] = lengths
+		}
+		for inDocID, length := range srcLengths {
+			if live.Contains(inDocID) {
+				lengths[inDocID] = length
+			}
+		}
+	}
+
+	for field, srcFieldID := range src.fieldToFieldId {
+		srcPostings, ok := src.rangePostings[srcFieldID]
+		if !ok {
+			continue
+		}
+
+		fieldID := seg.fieldID(field)
+		fieldPostings, ok := seg.rangePostings[fieldID]
+		if !ok {
+			list := NewRangePostingList()
+			fieldPostings = &list
+			seg.rangePostings[fieldID] = fieldPostings
+		}
+		fieldPostings.merge(srcPostings, live)
+	}
+
+	for field, srcFieldID := range src.fieldToFieldId {
+		srcPostings, ok := src.boolPostings[srcFieldID]
+		if !ok {
+			continue
+		}
+
+		fieldID := seg.fieldID(field)
+		fieldPostings, ok := seg.boolPostings[fieldID]
+		if !ok {
+			fieldPostings = NewBoolPostingList()
+			seg.boolPostings[fieldID] = fieldPostings
+		}
+		fieldPostings.trues.Or(roaring.And(srcPostings.trues, live))
+		fieldPostings.falses.Or(roaring.And(srcPostings.falses, live))
+	}
+}
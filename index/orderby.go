@@ -0,0 +1,180 @@
+package index
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/blevesearch/vellum"
+)
+
+// sortValue is the value a doc is ordered by for one order by field
+type sortValue struct {
+	ok  bool // false if the doc has no value for the field, such docs are ordered last
+	num Item // for range fields
+	str string
+}
+
+// compare returns -1, 0, 1 if a is less than, equal to or greater than b
+func (a sortValue) compare(b sortValue) int {
+	switch {
+	case !a.ok || !b.ok:
+		return 0
+	case a.num.kind != 0:
+		if bTreeLess(a.num, b.num) {
+			return -1
+		} else if bTreeLess(b.num, a.num) {
+			return 1
+		}
+		return 0
+	case a.str < b.str:
+		return -1
+	case a.str > b.str:
+		return 1
+	}
+	return 0
+}
+
+// orderHits sorts the hits by the order by fields using the index rather than the docs themselves: the range
+// postings btree is walked in order for numeric fields, the FST in order for string fields. A doc of a
+// multi-valued field is ordered by its lowest value when ascending, by its highest when descending.
+func (snap *snapshot) orderHits(hits []Hit, orderby []*OrderBy) error {
+	inDocIDs := make([]uint32, len(hits))
+	want := roaring.New()
+	for j, hit := range hits {
+		inDocID, ok := snap.locate(hit.Key)
+		if !ok {
+			return fmt.Errorf("doc:%s not found in index", hit.Key)
+		}
+		inDocIDs[j] = inDocID
+		want.Add(inDocID)
+	}
+
+	values := make([]map[uint32]sortValue, 0, len(orderby))
+	for _, ob := range orderby {
+		vals, err := snap.sortValues(ob, want)
+		if err != nil {
+			return err
+		}
+		values = append(values, vals)
+	}
+
+	order := make([]int, len(hits))
+	for j := range order {
+		order[j] = j
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ida, idb := inDocIDs[order[a]], inDocIDs[order[b]]
+		for k, ob := range orderby {
+			va, vb := values[k][ida], values[k][idb]
+			if va.ok != vb.ok {
+				return va.ok // docs without value go last
+			}
+
+			c := va.compare(vb)
+			if c == 0 {
+				continue
+			}
+			return (c < 0) == ob.Ascend
+		}
+		return false
+	})
+
+	sorted := make([]Hit, len(hits))
+	for j, o := range order {
+		sorted[j] = hits[o]
+	}
+	copy(hits, sorted)
+	return nil
+}
+
+// sortValues collects the value of the order by field for the docs in `want`
+func (snap *snapshot) sortValues(ob *OrderBy, want *roaring.Bitmap) (map[uint32]sortValue, error) {
+	if ob == nil {
+		return nil, fmt.Errorf("order by field must not be nil")
+	}
+
+	known := false
+	values := make(map[uint32]sortValue, want.GetCardinality())
+	for _, seg := range snap.segments {
+		fieldID, ok := seg.fieldToFieldId[ob.FieldName]
+		if !ok {
+			continue
+		}
+		known = true
+
+		segWant := roaring.And(want, seg.fullDocIDBits)
+		if segWant.IsEmpty() {
+			continue
+		}
+
+		if fieldPostings, ok := seg.rangePostings[fieldID]; ok {
+			iter := func(item Item) bool {
+				for it := roaring.And(item.postings, segWant).Iterator(); it.HasNext(); {
+					inDocID := it.Next()
+					if _, ok := values[inDocID]; !ok {
+						values[inDocID] = sortValue{ok: true, num: Item{numeric: item.numeric, kind: item.kind}}
+					}
+				}
+				return true
+			}
+			if ob.Ascend {
+				fieldPostings.rangePosting.Scan(iter)
+			} else {
+				fieldPostings.rangePosting.Reverse(iter)
+			}
+		}
+
+		if fieldPostings, ok := seg.boolPostings[fieldID]; ok {
+			// false < true, bools are ordered as the numbers 0 and 1
+			vals := []bool{false, true}
+			if !ob.Ascend {
+				vals = []bool{true, false}
+			}
+			for _, val := range vals {
+				num := Item{numeric: int64(0), kind: reflect.Int64}
+				if val {
+					num.numeric = int64(1)
+				}
+				for it := roaring.And(fieldPostings.Postings(val), segWant).Iterator(); it.HasNext(); {
+					values[it.Next()] = sortValue{ok: true, num: num}
+				}
+			}
+		}
+
+		if fst, ok := seg.termDicFstCache[fieldID]; ok {
+			// the FST only iterates in ascending order, when descending the last (highest) term wins
+			itr, err := fst.Iterator(nil, nil)
+			for ; err == nil; err = itr.Next() {
+				term, termID := itr.Current()
+				postings := roaring.And(seg.postings[uint32(termID)].Postings(), segWant)
+				for it := postings.Iterator(); it.HasNext(); {
+					inDocID := it.Next()
+					if _, ok := values[inDocID]; !ok || !ob.Ascend {
+						values[inDocID] = sortValue{ok: true, str: string(term)}
+					}
+				}
+			}
+			if err != vellum.ErrIteratorDone {
+				return nil, fmt.Errorf("failed iterating term dictionary of field:%s: %v", ob.FieldName, err)
+			}
+		}
+	}
+
+	if !known {
+		return nil, fmt.Errorf("order by field:%s is not indexed", ob.FieldName)
+	}
+	return values, nil
+}
+
+// locate returns the internal doc id of the live version of the doc
+func (snap *snapshot) locate(key string) (uint32, bool) {
+	for j := len(snap.segments) - 1; j >= 0; j-- {
+		inDocID, ok := snap.segments[j].docIDExternalToInternal[key]
+		if ok && !snap.deleted.Contains(inDocID) {
+			return inDocID, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,157 @@
+package index
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/araddon/qlbridge/value"
+)
+
+// 参数化查询：查询中的比较值可以使用占位符，值通过 Params 传入，而不是拼接到查询字符串中，避免引号转义以及注入问题：
+//
+//	idx.QueryWithParams(`Age >= :minAge && in_array(City, :cities)`, index.Params{"minAge": 18, "cities": []string{"深圳", "广州"}})
+//	idx.QueryWithParams(`Age >= ? && City == ?`, index.Args(18, "深圳"))
+//
+// 命名占位符 `:name` 按名称绑定，位置占位符 `?` 从左到右依次绑定 "1"、"2"...，见 Args。占位符只能出现在比较值的位置。
+
+// Params are the values of the placeholders of a query, by name. Values are go values: integers, floats, strings,
+// bools, time.Time, time.Duration and slices of them for `in_array`.
+type Params map[string]any
+
+// Args binds the positional placeholders `?` of a query, from left to right
+func Args(values ...any) Params {
+	params := make(Params, len(values))
+	for i, v := range values {
+		params[strconv.Itoa(i+1)] = v
+	}
+	return params
+}
+
+// parsedQuery is a query parsed once and for all, the placeholders are replaced by identifiers of the same length
+// so the positions of the nodes are those of the query text
+type parsedQuery struct {
+	expr         ast.Expr
+	fset         *token.FileSet
+	placeholders map[token.Pos]string // position of the identifier replacing a placeholder --> parameter name
+
+	automata *automatonCache           // automata compiled by the executions of a prepared query, nil for a one-off query
+	explain  map[ast.Expr]*ExplainNode // nodes of the plan by condition when the query is explained, see Index.Explain
+}
+
+// parseQuery parses a query, rewriting `:name` to `_name` and `?` to `_` before it's parsed as a go expression
+func parseQuery(query string) (*parsedQuery, error) {
+	src := []byte(query)
+	offsets := make(map[int]string) // byte offset of a placeholder --> parameter name
+
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", fset.Base(), len(src)), src, nil, 0)
+	prevColon, positional := -1, 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		offset := fset.Position(pos).Offset
+		switch {
+		case tok == token.IDENT && prevColon >= 0 && prevColon+1 == offset: // `:name`, without blank in between
+			src[prevColon] = '_'
+			offsets[prevColon] = lit
+		case tok == token.ILLEGAL && lit == "?":
+			src[offset] = '_'
+			positional++ // numbered apart from the named placeholders, as by Args
+			offsets[offset] = strconv.Itoa(positional)
+		}
+		prevColon = -1
+		if tok == token.COLON {
+			prevColon = offset
+		}
+	}
+
+	fset = token.NewFileSet()
+	expr, err := parser.ParseExprFrom(fset, "", src, 0)
+	if err != nil {
+		return nil, parseError(err, src)
+	}
+
+	pq := &parsedQuery{expr: expr, fset: fset, placeholders: make(map[token.Pos]string, len(offsets))}
+	file := fset.File(expr.Pos())
+	for offset, name := range offsets {
+		pq.placeholders[file.Pos(offset)] = name
+	}
+	return pq, nil
+}
+
+// bind converts the values of the placeholders of the query, a placeholder without value is an error while the
+// params the query doesn't use are ignored
+func (pq *parsedQuery) bind(params Params) (map[token.Pos]value.Value, error) {
+	if len(pq.placeholders) == 0 {
+		return nil, nil
+	}
+
+	bound := make(map[token.Pos]value.Value, len(pq.placeholders))
+	for pos, name := range pq.placeholders {
+		v, ok := params[name]
+		if !ok {
+			return nil, fmt.Errorf("missing value for the placeholder %s", placeholderName(name))
+		}
+		val, err := paramValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("placeholder %s: %v", placeholderName(name), err)
+		}
+		bound[pos] = val
+	}
+	return bound, nil
+}
+
+func placeholderName(name string) string {
+	if _, err := strconv.Atoi(name); err == nil {
+		return "?" + name
+	}
+	return ":" + name
+}
+
+// paramValue converts the value of a parameter to the value it's compared with, as the literals of the query are
+func paramValue(v any) (value.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return value.NilValueVal, fmt.Errorf("nil value")
+	case value.Value:
+		return v, nil
+	case time.Time:
+		return value.NewTimeValue(v), nil
+	case time.Duration:
+		return NewDurationValue(v), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return value.NilValueVal, fmt.Errorf("nil value")
+		}
+		return paramValue(rv.Elem().Interface())
+	}
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array { // the elements of `in_array`
+		elems := make([]value.Value, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			elem, err := paramValue(rv.Index(i).Interface())
+			if err != nil {
+				return value.NilValueVal, err
+			}
+			elems = append(elems, elem)
+		}
+		return value.NewSliceValues(elems), nil
+	}
+
+	val := newLeafValue(rv)
+	if err, ok := val.(value.ErrorValue); ok {
+		return value.NilValueVal, err.Val()
+	}
+	return val, nil
+}
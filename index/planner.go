@@ -0,0 +1,244 @@
+package index
+
+import (
+	"go/ast"
+	"go/token"
+	"sort"
+	"time"
+)
+
+// 查询计划：`&&` 连接的条件被展开为一组子句，按照从倒排表大小估算的匹配文档数从小到大依次求值，结果为空时跳过剩余的
+// 子句；`a && !b` 从 a 的结果中直接去掉 b 的文档（AndNot），不再计算 b 的补集。估算不需要执行子句：
+//   - 等值条件、bool 字段以及 match 的匹配文档数直接取自倒排表的大小
+//   - in_array 为各个元素之和，`||` 为两侧之和，`!` 为段的文档数减去被取反的条件
+//   - 范围条件估算为段的文档数的一半，正则、前缀、通配符、模糊匹配以及用户函数需要遍历词典或无法估算，按段的文档数
+//     估算，放在最后求值
+//
+// 跳过的子句不会被求值，其中的错误（如对 bool 字段使用 like）也不会被报告，这类错误由查询校验（见 Index.Validate）
+// 提前发现。
+
+// clause is a condition of an `&&` chain
+type clause struct {
+	expr     ast.Expr // the condition, or the negated condition for `!x`
+	not      ast.Expr // the `!x` expression of a negated condition, nil otherwise
+	estimate uint64   // estimated docs of the segment the condition matches
+}
+
+// andClauses flattens a chain of `&&` into its conditions, in the order of the query
+func andClauses(expr ast.Expr, clauses []clause) []clause {
+	if and, ok := unparen(expr).(*ast.BinaryExpr); ok && and.Op == token.LAND {
+		return andClauses(and.Y, andClauses(and.X, clauses))
+	}
+	if not, ok := unparen(expr).(*ast.UnaryExpr); ok && not.Op == token.NOT {
+		return append(clauses, clause{expr: not.X, not: not})
+	}
+	return append(clauses, clause{expr: expr})
+}
+
+// node returns the expression of the clause as found in the plan of Index.Explain
+func (c clause) node() ast.Expr {
+	if c.not != nil {
+		return c.not
+	}
+	return unparen(c.expr)
+}
+
+func unparen(expr ast.Expr) ast.Expr {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = paren.X
+	}
+}
+
+// evalAnd evaluates the conditions of an `&&` chain, the most selective first, and stops as soon as nothing matches
+func evalAnd(expr *ast.BinaryExpr, ec *evalContext) (*SearchResults, error) {
+	clauses := andClauses(expr, nil)
+	var positive, negative []clause
+	for _, c := range clauses {
+		c.estimate = ec.estimate(c.expr)
+		ec.explainEstimate(c)
+		if c.not != nil {
+			negative = append(negative, c)
+		} else {
+			positive = append(positive, c)
+		}
+	}
+	sort.SliceStable(positive, func(i, j int) bool { return positive[i].estimate < positive[j].estimate })
+	// the negated conditions removing the most docs first, the sooner nothing is left the more are skipped
+	sort.SliceStable(negative, func(i, j int) bool { return negative[i].estimate > negative[j].estimate })
+
+	var res *SearchResults
+	if len(positive) == 0 { // `!a && !b`: the docs of the segment without those of a and b
+		res = &SearchResults{ec.seg.fullDocIDBits.Clone(), nil, nil}
+	}
+	for j, c := range positive {
+		if res != nil && res.internalDocIds.IsEmpty() {
+			ec.explainSkipped(positive[j:], negative)
+			return res, nil
+		}
+
+		cres, err := qeval(c.expr, ec)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			res = cres
+		} else {
+			res.And(cres)
+		}
+	}
+
+	for j, c := range negative {
+		if res.internalDocIds.IsEmpty() {
+			ec.explainSkipped(nil, negative[j:])
+			return res, nil
+		}
+
+		start := time.Now()
+		cres, err := qeval(c.expr, ec)
+		if err != nil {
+			return nil, err
+		}
+		before := res.internalDocIds.GetCardinality()
+		res.AndNot(cres)
+		ec.explainAndNot(c, before-res.internalDocIds.GetCardinality(), time.Since(start))
+	}
+	return res, nil
+}
+
+// estimate returns the estimated number of docs of the segment the condition matches, from the sizes of the postings
+func (ec *evalContext) estimate(expr ast.Expr) uint64 {
+	seg := ec.seg
+	total := seg.fullDocIDBits.GetCardinality()
+
+	switch expr := expr.(type) {
+	case *ast.ParenExpr:
+		return ec.estimate(expr.X)
+	case *ast.UnaryExpr:
+		if expr.Op == token.NOT {
+			return total - min(total, ec.estimate(expr.X))
+		}
+	case *ast.BinaryExpr:
+		switch expr.Op {
+		case token.LAND:
+			return min(ec.estimate(expr.X), ec.estimate(expr.Y))
+		case token.LOR:
+			return min(total, ec.estimate(expr.X)+ec.estimate(expr.Y))
+		case token.EQL, token.NEQ:
+			eq := ec.estimateTerm(expr.X, expr.Y)
+			if expr.Op == token.NEQ {
+				return total - min(total, eq)
+			}
+			return eq
+		case token.LSS, token.LEQ, token.GEQ, token.GTR:
+			return total / 2
+		}
+	case *ast.Ident, *ast.SelectorExpr: // bool field as a condition
+		field, err := parseIdent(expr)
+		if err != nil {
+			return total
+		}
+		return min(total, seg.estimateQuery(&BoolQuery{field, true}))
+	case *ast.CallExpr:
+		fn, ok := expr.Fun.(*ast.Ident)
+		if !ok || len(expr.Args) < 2 {
+			return total
+		}
+		switch fn.Name {
+		case "in_array":
+			elts, ok := expr.Args[1].(*ast.CompositeLit)
+			if !ok {
+				return total
+			}
+			var sum uint64
+			for _, elt := range elts.Elts {
+				sum += ec.estimateTerm(expr.Args[0], elt)
+			}
+			return min(total, sum)
+		case "match":
+			field, err := parseIdent(expr.Args[0])
+			text, lerr := parseBasicLit(expr.Args[1], ec)
+			if err != nil || lerr != nil {
+				return total
+			}
+			return min(total, seg.estimateQuery(&MatchQuery{FieldName: field, Text: text.ToString()}))
+		case "between":
+			return total / 2
+		}
+	}
+	return total // regular expressions, user functions..., evaluated last
+}
+
+// estimateTerm estimates the docs whose field equals the literal
+func (ec *evalContext) estimateTerm(ident, lit ast.Expr) uint64 {
+	total := ec.seg.fullDocIDBits.GetCardinality()
+	field, err := parseIdent(ident)
+	if err != nil {
+		return total
+	}
+	val, err := parseBasicLit(lit, ec)
+	if err != nil {
+		return total
+	}
+	query, err := NewQuery(TypeTermQuery, field, val)
+	if err != nil {
+		return total
+	}
+	return min(total, ec.seg.estimateQuery(query))
+}
+
+// estimateQuery returns the number of docs of the postings of an equality or a match query, or of the segment for
+// the other queries
+func (seg *Segment) estimateQuery(query Query) uint64 {
+	total := seg.fullDocIDBits.GetCardinality()
+	switch q := query.(type) {
+	case *TermQuery:
+		return seg.estimateTerms(q.FieldName, q.Term)
+	case *MatchQuery:
+		return seg.estimateTerms(q.FieldName, q.Text)
+	case *BoolQuery:
+		if postings, ok := seg.boolPostings[seg.fieldToFieldId[q.FieldName]]; ok {
+			return postings.Postings(q.Value).GetCardinality()
+		}
+	case *RangeQuery:
+		if postings, ok := seg.rangePostings[seg.fieldToFieldId[q.FieldName]]; ok && q.qtype == TypeRangeEQQuery {
+			return postings.Query(q.qtype, q.Num).GetCardinality()
+		}
+	}
+	return total
+}
+
+// estimateTerms returns the docs holding the term, or at most the docs of the rarest token of the text for a text
+// field
+func (seg *Segment) estimateTerms(field, text string) uint64 {
+	total := seg.fullDocIDBits.GetCardinality()
+	fieldId, ok := seg.fieldToFieldId[field]
+	if !ok {
+		return total // the evaluation reports the unknown field
+	}
+	termDictionary, ok := seg.fieldsTermDic[fieldId]
+	if !ok {
+		return total
+	}
+
+	terms := []string{seg.normalize(fieldId, text)}
+	if fa, ok := seg.analyzers[fieldId]; ok {
+		terms = terms[:0]
+		for _, tok := range fa.analyzer.Analyze(text) {
+			terms = append(terms, tok.Term)
+		}
+	}
+
+	estimate := total
+	for _, term := range terms {
+		termID, ok := termDictionary.termToTermID[term]
+		if !ok {
+			return 0
+		}
+		estimate = min(estimate, seg.postings[uint32(termID)].Postings().GetCardinality())
+	}
+	return estimate
+}
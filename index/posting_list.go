@@ -2,6 +2,7 @@ package index
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 
 	"github.com/RoaringBitmap/roaring"
@@ -21,6 +22,37 @@ func (p TermPostingList) Postings() *roaring.Bitmap {
 	return p.postings
 }
 
+// BoolPostingList holds the docs of a bool field, split by value
+type BoolPostingList struct {
+	trues  *roaring.Bitmap
+	falses *roaring.Bitmap
+}
+
+func NewBoolPostingList() *BoolPostingList {
+	return &BoolPostingList{trues: roaring.New(), falses: roaring.New()}
+}
+
+// Postings returns the docs whose field value is `val`
+func (b *BoolPostingList) Postings(val bool) *roaring.Bitmap {
+	if val {
+		return b.trues
+	}
+	return b.falses
+}
+
+func (b *BoolPostingList) Add(val bool, docid uint32) {
+	b.Postings(val).Add(docid)
+}
+
+func (b *BoolPostingList) Remove(docid uint32) {
+	b.trues.Remove(docid)
+	b.falses.Remove(docid)
+}
+
+func (b *BoolPostingList) clone() *BoolPostingList {
+	return &BoolPostingList{trues: b.trues.Clone(), falses: b.falses.Clone()}
+}
+
 type RangePostingList struct {
 	rangePosting btree.BTreeG[Item]
 	numberKind   reflect.Kind
@@ -63,8 +95,8 @@ func RangePostingAdd[T numeric](rp *RangePostingList, num T, docid uint32) error
 
 func (r *RangePostingList) Add(num interface{}, docid uint32) error {
 	inKind := reflect.TypeOf(num).Kind()
-	if inKind != reflect.Int64 && inKind != reflect.Float64 {
-		return fmt.Errorf("num must be an int64 or float64, got %T", num)
+	if inKind != reflect.Int64 && inKind != reflect.Uint64 && inKind != reflect.Float64 {
+		return fmt.Errorf("num must be an int64, uint64 or float64, got %T", num)
 	}
 
 	if r.numberKind == reflect.Invalid {
@@ -88,6 +120,180 @@ func (r *RangePostingList) add(item Item, docid uint32) {
 	r.rangePosting.Set(index)
 }
 
+// clone returns a deep copy of the posting list
+func (r *RangePostingList) clone() *RangePostingList {
+	c := NewRangePostingList()
+	c.numberKind = r.numberKind
+	r.rangePosting.Scan(func(item Item) bool {
+		c.rangePosting.Set(Item{numeric: item.numeric, kind: item.kind, postings: item.postings.Clone()})
+		return true
+	})
+	return &c
+}
+
+// merge adds the postings of `src` restricted to the docs in `live`
+func (r *RangePostingList) merge(src *RangePostingList, live *roaring.Bitmap) {
+	if r.numberKind == reflect.Invalid {
+		r.numberKind = src.numberKind
+	}
+
+	src.rangePosting.Scan(func(item Item) bool {
+		postings := roaring.And(item.postings, live)
+		if postings.IsEmpty() {
+			return true
+		}
+
+		index, ok := r.rangePosting.Get(item)
+		if !ok {
+			index = Item{numeric: item.numeric, kind: item.kind, postings: roaring.New()}
+		}
+		index.postings.Or(postings)
+		r.rangePosting.Set(index)
+		return true
+	})
+}
+
+// Remove drops the docid from every value it was indexed with, values left without any document are removed.
+func (r *RangePostingList) Remove(docid uint32) {
+	var emptied []Item
+	r.rangePosting.Scan(func(item Item) bool {
+		if item.postings.CheckedRemove(docid) && item.postings.IsEmpty() {
+			emptied = append(emptied, item)
+		}
+		return true
+	})
+
+	for _, item := range emptied {
+		r.rangePosting.Delete(item)
+	}
+}
+
+// removeValue drops the docid from the postings of a value, the value is removed once no document holds it
+func (r *RangePostingList) removeValue(num interface{}, docid uint32) {
+	item, ok := r.rangePosting.Get(Item{numeric: num, kind: r.numberKind})
+	if ok && item.postings.CheckedRemove(docid) && item.postings.IsEmpty() {
+		r.rangePosting.Delete(item)
+	}
+}
+
+// Query returns the docs whose value compares to `num` by the range query type `qtype`. `num` is an int64, a uint64
+// or a float64, it's converted to the number kind of the list first, see coerce.
+func (r *RangePostingList) Query(qtype QType, num interface{}) *roaring.Bitmap {
+	item, match := r.coerce(qtype, num)
+	switch match {
+	case matchNone:
+		return roaring.New()
+	case matchAll:
+		return r.numericRange(Item{}, false, true)
+	}
+
+	switch qtype {
+	case TypeRangeEQQuery:
+		return r.Equal(item)
+	case TypeRangeGEQuery:
+		return r.GraterEqual(item)
+	case TypeRangeGTQuery:
+		return r.GraterThan(item)
+	case TypeRangeLEQuery:
+		return r.LessEqual(item)
+	case TypeRangeLTQuery:
+		return r.LessThan(item)
+	}
+	return roaring.New()
+}
+
+type coerceMatch int
+
+const (
+	matchSome coerceMatch = iota // compare with the coerced item
+	matchNone                    // no value satisfies the comparison
+	matchAll                     // every value satisfies the comparison
+)
+
+// coerce converts `num` to the number kind of the list, comparisons keep their numeric meaning:
+//   - an integer is compared to a float field as a float: `Score >= 1` is `Score >= 1.0`.
+//   - a float is compared to an integer field exactly, by rounding the bound: `Age > 1.5` is `Age > 1`,
+//     `Age <= 1.5` is `Age <= 1` and `Age == 1.5` matches nothing.
+//   - bounds out of the range of the integer kind of the field match all or no value: `Size > -1` matches all the
+//     values of an unsigned field, `Age < 1<<63` all the values of a signed one.
+//   - NaN is not equal to, less or greater than any number, it matches no value.
+func (r *RangePostingList) coerce(qtype QType, num interface{}) (Item, coerceMatch) {
+	switch r.numberKind {
+	case reflect.Float64:
+		switch n := num.(type) {
+		case int64:
+			num = float64(n)
+		case uint64:
+			num = float64(n)
+		case float64:
+			if math.IsNaN(n) {
+				return Item{}, matchNone
+			}
+		}
+	case reflect.Int64, reflect.Uint64:
+		var bound float64
+		switch n := num.(type) {
+		case int64:
+			if r.numberKind == reflect.Int64 {
+				return Item{numeric: n, kind: r.numberKind}, matchSome
+			}
+			if n >= 0 {
+				return Item{numeric: uint64(n), kind: r.numberKind}, matchSome
+			}
+			bound = float64(n)
+		case uint64:
+			if r.numberKind == reflect.Uint64 {
+				return Item{numeric: n, kind: r.numberKind}, matchSome
+			}
+			if n <= math.MaxInt64 {
+				return Item{numeric: int64(n), kind: r.numberKind}, matchSome
+			}
+			bound = float64(n)
+		case float64:
+			if math.IsNaN(n) {
+				return Item{}, matchNone
+			}
+
+			// integers below the bound: `x < f` is `x < ceil(f)`, `x <= f` is `x <= floor(f)`, the other way
+			// round for integers above it
+			bound = math.Ceil(n)
+			if qtype == TypeRangeLEQuery || qtype == TypeRangeGTQuery {
+				bound = math.Floor(n)
+			}
+			if qtype == TypeRangeEQQuery && bound != n {
+				return Item{}, matchNone
+			}
+		}
+
+		lo, hi := float64(math.MinInt64), float64(math.MaxInt64) // 2^63 and above overflow int64
+		if r.numberKind == reflect.Uint64 {
+			lo, hi = 0, math.MaxUint64 // 2^64 and above overflow uint64
+		}
+		switch below := qtype == TypeRangeLTQuery || qtype == TypeRangeLEQuery; {
+		case bound >= hi:
+			if below {
+				return Item{}, matchAll
+			}
+			return Item{}, matchNone
+		case bound < lo:
+			if below || qtype == TypeRangeEQQuery {
+				return Item{}, matchNone
+			}
+			return Item{}, matchAll
+		}
+
+		if r.numberKind == reflect.Uint64 {
+			num = uint64(bound)
+		} else {
+			num = int64(bound)
+		}
+	case reflect.Invalid: // no value indexed yet
+		return Item{}, matchNone
+	}
+
+	return Item{numeric: num, kind: r.numberKind}, matchSome
+}
+
 // Equal
 func (r *RangePostingList) Equal(num Item) *roaring.Bitmap {
 	item, ok := r.rangePosting.Get(num)
@@ -116,6 +322,14 @@ func (r *RangePostingList) GraterEqual(num Item) *roaring.Bitmap {
 
 func (r RangePostingList) numericRange(num Item, lt, includeNum bool) *roaring.Bitmap {
 	posting := roaring.New()
+	if num.kind == reflect.Invalid { // no pivot, the whole range
+		r.rangePosting.Scan(func(item Item) bool {
+			posting.Or(item.postings)
+			return true
+		})
+		return posting
+	}
+
 	iter := func(item Item) bool {
 		if !includeNum && bTreeEqual(num, item) { // skip pivot number
 			return true
@@ -134,7 +348,7 @@ func (r RangePostingList) numericRange(num Item, lt, includeNum bool) *roaring.B
 }
 
 type Item struct {
-	numeric  interface{} // int64 float64
+	numeric  interface{} // int64 uint64 float64
 	kind     reflect.Kind
 	postings *roaring.Bitmap
 }
@@ -147,6 +361,8 @@ func bTreeLess(a, b Item) bool {
 		return a.numeric.(float64) < b.numeric.(float64)
 	case reflect.Int64:
 		return a.numeric.(int64) < b.numeric.(int64)
+	case reflect.Uint64:
+		return a.numeric.(uint64) < b.numeric.(uint64)
 	}
 
 	return false
@@ -158,10 +374,12 @@ func bTreeEqual(a, b Item) bool {
 		return a.numeric.(float64) == b.numeric.(float64)
 	case reflect.Int64:
 		return a.numeric.(int64) == b.numeric.(int64)
+	case reflect.Uint64:
+		return a.numeric.(uint64) == b.numeric.(uint64)
 	}
 	return false
 }
 
 type numeric interface {
-	int64 | float64
+	int64 | uint64 | float64
 }
@@ -0,0 +1,56 @@
+package index
+
+// PreparedQuery 预编译的查询：查询只解析一次，查询在 Prepare 时即按照索引的 Mapping 校验（见 Index.Validate），正则、通配符以及模糊匹配的
+// 自动机在首次执行时编译并被之后的执行复用。每次执行都查询索引的最新数据，`now()` 以及参数在每次执行时重新取值。
+// PreparedQuery 可以被并发执行。
+//
+//	pq, err := idx.Prepare(`Age >= :minAge && like(Name.First, "vic.*")`)
+//	docs, err := pq.QueryWithParams(index.Params{"minAge": 22})
+type PreparedQuery struct {
+	idx   *Index
+	query string
+	pq    *parsedQuery
+}
+
+// Prepare 解析并检查查询，返回可以多次执行的 PreparedQuery
+func (i *Index) Prepare(query string) (*PreparedQuery, error) {
+	pq, err := i.parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	pq.automata = &automatonCache{}
+	return &PreparedQuery{idx: i, query: query, pq: pq}, nil
+}
+
+// String returns the text of the query
+func (p *PreparedQuery) String() string {
+	return p.query
+}
+
+// Query 执行查询，见 Index.Query
+func (p *PreparedQuery) Query(opts ...OptionFunc) ([]interface{}, error) {
+	return p.QueryWithParams(nil, opts...)
+}
+
+// QueryWithParams 使用参数执行查询，见 Index.QueryWithParams
+func (p *PreparedQuery) QueryWithParams(params Params, opts ...OptionFunc) ([]interface{}, error) {
+	snap := p.idx.snap.Load()
+	res, err := snap.run(p.pq, params)
+	if err != nil {
+		return nil, err
+	}
+
+	hits, err := snap.hits(res.ExternalDocIDs, nil, opts...)
+	return hitDocs(hits), err
+}
+
+// Search 执行查询并返回文档的相关性得分，见 Index.Search
+func (p *PreparedQuery) Search(opts ...OptionFunc) ([]Hit, error) {
+	snap := p.idx.snap.Load()
+	res, err := snap.run(p.pq, nil)
+	if err != nil {
+		return nil, err
+	}
+	return snap.scoredHits(res, opts...)
+}
@@ -0,0 +1,96 @@
+package index
+
+import (
+	"math"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// BM25 parameters: k1 controls the saturation of the term frequency, b the normalization by the field length
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// textStats are the statistics of a text field the BM25 scores are computed from. They're collected over all the
+// segments of the index, so the score of a doc doesn't depend on the segment it lives in.
+type textStats struct {
+	docCount uint64            // live docs holding a value for the field
+	totalLen uint64            // sum of the field lengths of these docs
+	docFreqs map[string]uint64 // term --> live docs holding the term
+}
+
+// collectTextStats collects the statistics of the field for the given tokens, the deleted docs are ignored
+func collectTextStats(segments []*Segment, deleted *roaring.Bitmap, field string, tokens []Token) *textStats {
+	stats := &textStats{docFreqs: make(map[string]uint64, len(tokens))}
+	for _, tok := range tokens {
+		stats.docFreqs[tok.Term] = 0
+	}
+
+	for _, seg := range segments {
+		fieldID, ok := seg.fieldToFieldId[field]
+		if !ok {
+			continue
+		}
+
+		for inDocID, length := range seg.fieldLengths[fieldID] {
+			if seg.fullDocIDBits.Contains(inDocID) && !deleted.Contains(inDocID) {
+				stats.docCount++
+				stats.totalLen += uint64(length)
+			}
+		}
+
+		termDictionary, ok := seg.fieldsTermDic[fieldID]
+		if !ok {
+			continue
+		}
+		for term := range stats.docFreqs {
+			termID, ok := termDictionary.termToTermID[term]
+			if !ok {
+				continue
+			}
+			postings := seg.postings[termID].Postings()
+			stats.docFreqs[term] += postings.GetCardinality() - postings.AndCardinality(deleted)
+		}
+	}
+	return stats
+}
+
+// idf is the inverse document frequency of a term, rare terms weigh more
+func (stats *textStats) idf(term string) float64 {
+	n, df := float64(stats.docCount), float64(stats.docFreqs[term])
+	return math.Log(1 + (n-df+0.5)/(df+0.5))
+}
+
+// scoreBM25 scores the docs of `res` by the BM25 relevance of the field to the tokens
+func (seg *Segment) scoreBM25(res *SearchResults, fieldID uint32, termDictionary *IndexableField, tokens []Token, stats *textStats) {
+	if res.internalDocIds.IsEmpty() || stats.docCount == 0 {
+		return
+	}
+
+	avgLen := float64(stats.totalLen) / float64(stats.docCount)
+	lengths := seg.fieldLengths[fieldID]
+	seen := make(map[string]struct{}, len(tokens))
+	for _, tok := range tokens {
+		if _, ok := seen[tok.Term]; ok { // a term repeated in the query counts once
+			continue
+		}
+		seen[tok.Term] = struct{}{}
+
+		termID, ok := termDictionary.termToTermID[tok.Term]
+		if !ok {
+			continue
+		}
+		idf := stats.idf(tok.Term)
+		freqs := seg.termFreqs[termID]
+		for it := res.internalDocIds.Iterator(); it.HasNext(); {
+			inDocID := it.Next()
+			tf := float64(freqs[inDocID])
+			norm := 1 - bm25B
+			if avgLen > 0 {
+				norm += bm25B * float64(lengths[inDocID]) / avgLen
+			}
+			res.addScore(inDocID, idf*tf*(bm25K1+1)/(tf+bm25K1*norm))
+		}
+	}
+}
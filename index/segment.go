@@ -4,10 +4,16 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"maps"
+	"math"
+	"reflect"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/araddon/qlbridge/value"
+	"github.com/blevesearch/mmap-go"
 	"github.com/blevesearch/vellum"
 )
 
@@ -24,14 +30,30 @@ type Segment struct {
 	postings        map[uint32]TermPostingList // termID --> list of doc Ids // TODO replace with roaring bitmaps...
 
 	// for range index
-	rangePostings map[uint32]RangePostingList // fieldID --> btree(numeric --> posting list)
+	rangePostings map[uint32]*RangePostingList // fieldID --> btree(numeric --> posting list)
+
+	// for bool index
+	boolPostings map[uint32]*BoolPostingList // fieldID --> posting lists of true and false
+
+	// for text index
+	analyzers    map[uint32]fieldAnalyzer     // fieldID --> analyzer of the values of a text field and of its query values
+	termFreqs    map[uint32]map[uint32]uint32 // termID of a text field --> internal doc id --> occurrences of the term
+	fieldLengths map[uint32]map[uint32]uint32 // fieldID of a text field --> internal doc id --> number of tokens
+
+	stringOptions map[uint32]StringOption // fieldID --> how the values of a string field are compared
 
 	fullDocIDBits *roaring.Bitmap // store all doc IDs， using to handle not expression
 
+	// postings each doc was indexed into, so an update or a delete only visits the doc's own. Docs of merged and
+	// loaded segments have none, removing them scans all the postings.
+	docPostings map[uint32]*indexedDoc
+
 	// docid to doc
 	docIdInc                uint32
 	docIDInternalToExternal map[uint32]string
 	docIDExternalToInternal map[string]uint32
+
+	mmap mmap.MMap // mapping of the segment file the FSTs are read from, see OpenSegment
 }
 
 func NewSegment(n int32) *Segment {
@@ -41,7 +63,12 @@ func NewSegment(n int32) *Segment {
 		// fieldIdToTermDicBuilder: map[uint32]*vellum.Builder{},
 		termDicBytes:            make(map[uint32][]byte, n),
 		postings:                make(map[uint32]TermPostingList, n),
-		rangePostings:           make(map[uint32]RangePostingList, 5),
+		rangePostings:           make(map[uint32]*RangePostingList, 5),
+		boolPostings:            make(map[uint32]*BoolPostingList, 5),
+		analyzers:               make(map[uint32]fieldAnalyzer),
+		termFreqs:               make(map[uint32]map[uint32]uint32),
+		fieldLengths:            make(map[uint32]map[uint32]uint32),
+		stringOptions:           make(map[uint32]StringOption),
 		docIDInternalToExternal: make(map[uint32]string, n),
 		docIDExternalToInternal: make(map[string]uint32, n),
 		fullDocIDBits:           roaring.New(),
@@ -50,23 +77,29 @@ func NewSegment(n int32) *Segment {
 	}
 }
 
-// IndexDocuments All documents must be processed at once
+// IndexDocuments indexes the given documents into the segment. It can be called repeatedly: a document whose
+// external id is already indexed is replaced (its old terms are removed first), and only the term dictionaries of
+// the touched fields are rebuilt.
 func (seg *Segment) IndexDocuments(ctx context.Context, docs []Document) error {
 	// TODO for performance pass in a count of docs * fields so we can presize the array?
 	// TODO is it faster to count the terms first, so the array can be an exact size
-	// seg.fieldsTermDic = make(IndexableFields)
 	var err error
+	dirty := make(map[uint32]struct{}) // fields whose term dictionary must be rebuilt
 	for _, doc := range docs {
-		// TODO For performance we'll assume that each docId is unique?  That way we can just increment
-		//      the docIdInc counter on each doc without checking if the doc already exists.
+		// a document that can't be indexed is rejected before the segment is changed, so a failed update doesn't
+		// leave the previous version half removed
+		if err := seg.checkDocument(doc); err != nil {
+			return err
+		}
+
 		inDocID := uint32(0) // internal document id
 		if did, ok := seg.docIDExternalToInternal[doc.ID()]; ok {
 			inDocID = did
+			seg.removePostings(inDocID, dirty) // update: drop the terms of the previous version
 		} else {
 			inDocID = seg.docIdInc
 			seg.docIDExternalToInternal[doc.ID()] = inDocID
 			seg.docIDInternalToExternal[inDocID] = doc.ID()
-			// fmt.Println(doc.DocID)
 			seg.docIdInc++
 		}
 
@@ -84,22 +117,59 @@ func (seg *Segment) IndexDocuments(ctx context.Context, docs []Document) error {
 			//      to ensure that the term type match's the mapping type.
 			switch fieldTerm.Type() {
 			case value.StringType:
-				seg.processStringTerm(seg.fieldsTermDic, inDocID, field, fieldTerm.Value().(string))
+				if seg.processText(inDocID, field, fieldTerm.Value().(string)) {
+					dirty[seg.fieldID(field)] = struct{}{}
+				}
 			case value.IntType: // 整数
-				seg.processNumberFields(inDocID, field, fieldTerm.Value().(int64))
+				if perr := seg.processNumberFields(inDocID, field, fieldTerm.Value().(int64)); perr != nil {
+					err = perr
+				}
+			case UintType: // 无符号整数
+				if perr := seg.processNumberFields(inDocID, field, fieldTerm.Value().(uint64)); perr != nil {
+					err = perr
+				}
+			case value.NumberType: // 浮点数
+				if perr := seg.processNumberFields(inDocID, field, fieldTerm.Value().(float64)); perr != nil {
+					err = perr
+				}
 			case value.StringsType: //
 				vals := fieldTerm.Value().([]string)
 				for _, term := range vals {
-					seg.processStringTerm(seg.fieldsTermDic, inDocID, field, term)
+					if seg.processText(inDocID, field, term) {
+						dirty[seg.fieldID(field)] = struct{}{}
+					}
 				}
 			case IntSliceType:
 				vals := fieldTerm.Value().([]int64)
 				for _, term := range vals {
-					seg.processNumberFields(inDocID, field, term)
+					if perr := seg.processNumberFields(inDocID, field, term); perr != nil {
+						err = perr
+					}
+				}
+			case UintSliceType:
+				vals := fieldTerm.Value().([]uint64)
+				for _, term := range vals {
+					if perr := seg.processNumberFields(inDocID, field, term); perr != nil {
+						err = perr
+					}
+				}
+			case FloatSliceType:
+				vals := fieldTerm.Value().([]float64)
+				for _, term := range vals {
+					if perr := seg.processNumberFields(inDocID, field, term); perr != nil {
+						err = perr
+					}
+				}
+			case value.BoolType:
+				seg.processBoolField(inDocID, field, fieldTerm.Value().(bool))
+			case value.TimeType: // 时间按纳秒时间戳建立范围索引
+				nano, perr := unixNano(fieldTerm.Value().(time.Time))
+				if perr == nil {
+					perr = seg.processNumberFields(inDocID, field, nano)
+				}
+				if perr != nil {
+					err = perr
 				}
-			// case value.NumberType: // 浮点数
-			// case value.BoolType:
-			// case value.TimeType: //
 			default:
 				err = fmt.Errorf("type %v isn't currently supported", fieldTerm.Type())
 				continue
@@ -107,14 +177,193 @@ func (seg *Segment) IndexDocuments(ctx context.Context, docs []Document) error {
 		}
 	}
 
-	//
-	// Build the Term Dictionary, using an FST (vellum) for string types
-	//
-	// f, err := os.Create("/tmp/term.test.dic")
-	// if err != nil {
-	// 	return nil, err
-	// }
+	if berr := seg.buildTermDics(dirty); berr != nil {
+		return berr
+	}
+
+	return err
+}
+
+// checkDocument returns the error indexing the document would fail with: a value of an unsupported type, or a number
+// whose type differs from the one the range postings of the field hold
+func (seg *Segment) checkDocument(doc Document) error {
+	for field, fieldTerm := range doc.Row() {
+		if fieldTerm.Nil() || fieldTerm.Err() {
+			continue
+		}
+
+		var kind reflect.Kind
+		switch fieldTerm.Type() {
+		case value.StringType, value.StringsType, value.BoolType:
+			continue
+		case value.TimeType:
+			if _, err := unixNano(fieldTerm.Value().(time.Time)); err != nil {
+				return fmt.Errorf("field:%s %v", field, err)
+			}
+			kind = reflect.Int64
+		case value.IntType:
+			kind = reflect.Int64
+		case IntSliceType:
+			if len(fieldTerm.Value().([]int64)) == 0 {
+				continue
+			}
+			kind = reflect.Int64
+		case UintType:
+			kind = reflect.Uint64
+		case UintSliceType:
+			if len(fieldTerm.Value().([]uint64)) == 0 {
+				continue
+			}
+			kind = reflect.Uint64
+		case value.NumberType:
+			if math.IsNaN(fieldTerm.Value().(float64)) {
+				continue
+			}
+			kind = reflect.Float64
+		case FloatSliceType:
+			if len(fieldTerm.Value().([]float64)) == 0 {
+				continue
+			}
+			kind = reflect.Float64
+		default:
+			return fmt.Errorf("type %v isn't currently supported", fieldTerm.Type())
+		}
+
+		fieldID, ok := seg.fieldToFieldId[field]
+		if !ok {
+			continue
+		}
+		if postings, ok := seg.rangePostings[fieldID]; ok && postings.numberKind != reflect.Invalid && postings.numberKind != kind {
+			return fmt.Errorf("field:%s type mismatch. expected:%s, got:%s", field, postings.numberKind, kind)
+		}
+	}
+	return nil
+}
+
+// DeleteDocuments removes the given documents from the segment. Their internal ids are tombstoned: they are dropped
+// from every posting list and from `fullDocIDBits`, and are never handed out again, so `Not` and `!=` won't return
+// them. Unknown ids are ignored.
+func (seg *Segment) DeleteDocuments(ctx context.Context, ids []string) error {
+	dirty := make(map[uint32]struct{})
+	for _, id := range ids {
+		inDocID, ok := seg.docIDExternalToInternal[id]
+		if !ok {
+			continue
+		}
+
+		seg.removePostings(inDocID, dirty)
+		seg.fullDocIDBits.Remove(inDocID)
+		delete(seg.docIDExternalToInternal, id)
+		delete(seg.docIDInternalToExternal, inDocID)
+	}
+
+	return seg.buildTermDics(dirty)
+}
+
+// indexedDoc lists the postings a doc was indexed into
+type indexedDoc struct {
+	terms  []docTerm  // a term the doc holds several times is listed as many times
+	ranges []docRange // values of the range postings
+	bools  []uint32   // fieldIDs of the bool postings
+}
+
+type docTerm struct {
+	fieldID, termID uint32
+	term            string
+}
+
+type docRange struct {
+	fieldID uint32
+	num     interface{}
+}
+
+// indexed returns the postings the doc is indexed into, to record new ones
+func (seg *Segment) indexed(inDocID uint32) *indexedDoc {
+	if seg.docPostings == nil {
+		seg.docPostings = make(map[uint32]*indexedDoc)
+	}
+	doc, ok := seg.docPostings[inDocID]
+	if !ok {
+		doc = &indexedDoc{}
+		seg.docPostings[inDocID] = doc
+	}
+	return doc
+}
+
+// seal drops what only the updates of the segment need, a sealed segment is never updated again
+func (seg *Segment) seal() {
+	seg.docPostings = nil
+}
+
+// removePostings drops the internal doc id from its term, range and bool postings, only the doc's own postings are
+// visited when they were recorded. Terms left without any document are removed from the field's dictionary, and
+// only then the field is recorded in `dirty` so its FST gets rebuilt.
+func (seg *Segment) removePostings(inDocID uint32, dirty map[uint32]struct{}) {
+	doc, ok := seg.docPostings[inDocID]
+	if !ok {
+		seg.removeAllPostings(inDocID, dirty)
+		return
+	}
+	delete(seg.docPostings, inDocID)
+
+	for _, t := range doc.terms {
+		seg.removeTerm(inDocID, t.fieldID, t.term, t.termID, dirty)
+	}
+	for _, lengths := range seg.fieldLengths { // text fields
+		delete(lengths, inDocID)
+	}
+	for _, r := range doc.ranges {
+		seg.rangePostings[r.fieldID].removeValue(r.num, inDocID)
+	}
+	for _, fieldID := range doc.bools {
+		seg.boolPostings[fieldID].Remove(inDocID)
+	}
+}
+
+// removeAllPostings drops the internal doc id from all the postings of the segment, for a doc whose postings
+// weren't recorded
+func (seg *Segment) removeAllPostings(inDocID uint32, dirty map[uint32]struct{}) {
+	for fieldID, field := range seg.fieldsTermDic {
+		for term, termID := range field.termToTermID {
+			seg.removeTerm(inDocID, fieldID, term, termID, dirty)
+		}
+	}
+
+	for _, lengths := range seg.fieldLengths {
+		delete(lengths, inDocID)
+	}
+	for _, fieldPostings := range seg.rangePostings {
+		fieldPostings.Remove(inDocID)
+	}
+	for _, fieldPostings := range seg.boolPostings {
+		fieldPostings.Remove(inDocID)
+	}
+}
+
+// removeTerm drops the internal doc id from the postings of a term, the term is removed from the dictionary once
+// no document holds it anymore
+func (seg *Segment) removeTerm(inDocID, fieldID uint32, term string, termID uint32, dirty map[uint32]struct{}) {
+	postingList, ok := seg.postings[termID]
+	if !ok || !postingList.Postings().CheckedRemove(inDocID) {
+		return
+	}
+
+	if freqs, ok := seg.termFreqs[termID]; ok {
+		delete(freqs, inDocID)
+	}
+	if postingList.Postings().IsEmpty() {
+		delete(seg.fieldsTermDic[fieldID].termToTermID, term)
+		delete(seg.postings, termID)
+		delete(seg.termFreqs, termID)
+		dirty[fieldID] = struct{}{}
+		return
+	}
+	postingList.TermFrequency--
+	seg.postings[termID] = postingList
+}
 
+// buildTermDics (re)builds the term dictionary FST of the given fields, using an FST (vellum) for string types
+func (seg *Segment) buildTermDics(fieldIDs map[uint32]struct{}) error {
 	mkFst := func() (*vellum.Builder, *bytes.Buffer, error) {
 		buff := bytes.NewBuffer([]byte{})
 		var vellumOptions *vellum.BuilderOpts
@@ -125,11 +374,17 @@ func (seg *Segment) IndexDocuments(ctx context.Context, docs []Document) error {
 		return dic, buff, nil
 	}
 
-	for _, field := range seg.fieldsTermDic {
-		sort.Sort(field.Terms)
+	for fieldID := range fieldIDs {
+		field, ok := seg.fieldsTermDic[fieldID]
+		if !ok {
+			continue
+		}
 
-		// TODO lets stop saving the map of term dics builders on index and
-		// save them onto the IndexableField struct
+		field.Terms = make(Terms, 0, len(field.termToTermID))
+		for term, termID := range field.termToTermID {
+			field.Terms = append(field.Terms, &Term{Term: term, TermID: termID})
+		}
+		sort.Sort(field.Terms)
 
 		fst, buff, err := mkFst()
 		if err != nil {
@@ -145,11 +400,82 @@ func (seg *Segment) IndexDocuments(ctx context.Context, docs []Document) error {
 		if err := fst.Close(); err != nil {
 			return fmt.Errorf("vellum close failed:%v", err)
 		}
+		// the FST is loaded right away, so queries only ever read the cache and can run concurrently
+		termDictionary, err := vellum.Load(buff.Bytes())
+		if err != nil {
+			return fmt.Errorf("failed loading term dictionary: err:%v", err)
+		}
 		seg.termDicBytes[field.FieldID] = buff.Bytes()
+		seg.termDicFstCache[field.FieldID] = termDictionary
 		field.Terms = nil // 清理内存
 	}
 
-	return err
+	return nil
+}
+
+// clone returns a deep copy of the segment that is not affected by further writes to it. The FSTs are immutable
+// once built, so they are shared.
+func (seg *Segment) clone() *Segment {
+	c := &Segment{
+		fieldIdInt:              seg.fieldIdInt,
+		fieldToFieldId:          make(map[string]uint32, len(seg.fieldToFieldId)),
+		termIdInc:               seg.termIdInc,
+		termDicBytes:            make(map[uint32][]byte, len(seg.termDicBytes)),
+		termDicFstCache:         make(map[uint32]*vellum.FST, len(seg.termDicFstCache)),
+		fieldsTermDic:           make(IndexableFields, len(seg.fieldsTermDic)),
+		postings:                make(map[uint32]TermPostingList, len(seg.postings)),
+		rangePostings:           make(map[uint32]*RangePostingList, len(seg.rangePostings)),
+		boolPostings:            make(map[uint32]*BoolPostingList, len(seg.boolPostings)),
+		analyzers:               make(map[uint32]fieldAnalyzer, len(seg.analyzers)),
+		termFreqs:               make(map[uint32]map[uint32]uint32, len(seg.termFreqs)),
+		fieldLengths:            make(map[uint32]map[uint32]uint32, len(seg.fieldLengths)),
+		stringOptions:           maps.Clone(seg.stringOptions),
+		fullDocIDBits:           seg.fullDocIDBits.Clone(),
+		docIdInc:                seg.docIdInc,
+		docIDInternalToExternal: make(map[uint32]string, len(seg.docIDInternalToExternal)),
+		docIDExternalToInternal: make(map[string]uint32, len(seg.docIDExternalToInternal)),
+	}
+
+	for field, fieldID := range seg.fieldToFieldId {
+		c.fieldToFieldId[field] = fieldID
+	}
+	for fieldID, dic := range seg.termDicBytes {
+		c.termDicBytes[fieldID] = dic
+	}
+	for fieldID, fst := range seg.termDicFstCache {
+		c.termDicFstCache[fieldID] = fst
+	}
+	for fieldID, field := range seg.fieldsTermDic {
+		iField := NewIndexableField(field.FieldName, field.FieldID)
+		for term, termID := range field.termToTermID {
+			iField.termToTermID[term] = termID
+		}
+		c.fieldsTermDic[fieldID] = iField
+	}
+	for termID, postingList := range seg.postings {
+		c.postings[termID] = TermPostingList{postingList.TermFrequency, postingList.Postings().Clone()}
+	}
+	for fieldID, fieldPostings := range seg.rangePostings {
+		c.rangePostings[fieldID] = fieldPostings.clone()
+	}
+	for fieldID, fa := range seg.analyzers {
+		c.analyzers[fieldID] = fa
+	}
+	for termID, freqs := range seg.termFreqs {
+		c.termFreqs[termID] = maps.Clone(freqs)
+	}
+	for fieldID, lengths := range seg.fieldLengths {
+		c.fieldLengths[fieldID] = maps.Clone(lengths)
+	}
+	for fieldID, fieldPostings := range seg.boolPostings {
+		c.boolPostings[fieldID] = fieldPostings.clone()
+	}
+	for inDocID, externalID := range seg.docIDInternalToExternal {
+		c.docIDInternalToExternal[inDocID] = externalID
+		c.docIDExternalToInternal[externalID] = inDocID
+	}
+
+	return c
 }
 
 func (seg *Segment) fieldID(field string) uint32 {
@@ -164,8 +490,9 @@ func (seg *Segment) fieldID(field string) uint32 {
 }
 
 // processStringTerm processes value.Values of type string, if the wrong type is passed in then we'll get a panic
-func (seg *Segment) processStringTerm(fields IndexableFields, inDocID uint32, field string, term string) {
+func (seg *Segment) processStringTerm(fields IndexableFields, inDocID uint32, field string, term string) (uint32, bool) {
 	fieldID := seg.fieldID(field)
+	term = seg.normalize(fieldID, term)
 
 	// TODO is this the best way to index strutured data ?
 	iField, ok := fields[fieldID]
@@ -175,10 +502,11 @@ func (seg *Segment) processStringTerm(fields IndexableFields, inDocID uint32, fi
 	}
 
 	// Term ids are uniq to this instance (aka construction of) a segment.  They are not uniq across segments.
-	termID := uint32(0)
+	termID, added := uint32(0), false
 	if tid, ok := iField.termToTermID[term]; ok {
 		termID = tid
 	} else {
+		added = true
 		// termID = iField.terminIdInt
 		termID = seg.termIdInc
 		iField.termToTermID[term] = termID
@@ -186,30 +514,149 @@ func (seg *Segment) processStringTerm(fields IndexableFields, inDocID uint32, fi
 		seg.termIdInc++
 	}
 
-	iField.Terms = append(iField.Terms, &Term{Term: term, TermID: termID, InternalDocId: inDocID})
-
 	// fields = append(fields, &IndexableField{InternalDocId: docID, FieldID: fieldID, Term: term, TermID: termID})
 	if _, ok := seg.postings[termID]; ok {
 		postingList := seg.postings[termID]
-		postingList.Postings().Add(inDocID)
-		postingList.TermFrequency++
+		if postingList.Postings().CheckedAdd(inDocID) { // the number of docs, a text may hold a term several times
+			postingList.TermFrequency++
+		}
+		seg.postings[termID] = postingList
 	} else {
 		list := TermPostingList{1, roaring.New()}
 		list.Postings().Add(inDocID)
 		seg.postings[termID] = list
 	}
+	doc := seg.indexed(inDocID)
+	doc.terms = append(doc.terms, docTerm{fieldID, termID, term})
+	return termID, added
+}
+
+// processText indexes a string value: the tokens of a text field, the whole string otherwise. It reports whether
+// a term was added to the dictionary of the field, whose FST must then be rebuilt.
+func (seg *Segment) processText(inDocID uint32, field string, text string) bool {
+	fa, ok := seg.analyzers[seg.fieldID(field)]
+	if !ok {
+		_, added := seg.processStringTerm(seg.fieldsTermDic, inDocID, field, text)
+		return added
+	}
+
+	// term frequencies and field lengths are kept for scoring, see QueryMatch
+	tokens := fa.analyzer.Analyze(text)
+	dicChanged := false
+	for _, tok := range tokens {
+		termID, added := seg.processStringTerm(seg.fieldsTermDic, inDocID, field, tok.Term)
+		dicChanged = dicChanged || added
+		freqs, ok := seg.termFreqs[termID]
+		if !ok {
+			freqs = make(map[uint32]uint32)
+			seg.termFreqs[termID] = freqs
+		}
+		freqs[inDocID]++
+	}
+
+	fieldID := seg.fieldID(field)
+	lengths, ok := seg.fieldLengths[fieldID]
+	if !ok {
+		lengths = make(map[uint32]uint32)
+		seg.fieldLengths[fieldID] = lengths
+	}
+	lengths[inDocID] += uint32(len(tokens))
+	return dicChanged
 }
 
-func (seg *Segment) processNumberFields(inDocID uint32, field string, term int64) {
+// fieldAnalyzer is the analyzer of a text field, its name is persisted along with the segment
+type fieldAnalyzer struct {
+	name     string
+	analyzer Analyzer
+}
+
+// SetAnalyzer 设置文本字段的分析器，字段值按照分析器切分的词元建立索引，查询值也使用同一分析器处理。
+// 需要在索引该字段之前设置
+func (seg *Segment) SetAnalyzer(field string, name string) error {
+	analyzer, err := GetAnalyzer(name)
+	if err != nil {
+		return err
+	}
+
+	seg.analyzers[seg.fieldID(field)] = fieldAnalyzer{name: name, analyzer: analyzer}
+	return nil
+}
+
+// StringOption changes how the values of a string field are compared, options are combined with `|`
+type StringOption uint32
+
+const (
+	// NaturalOrder compares the runs of digits by their numeric values in range queries, so that `2.10.0` > `2.9.0`,
+	// see naturalCompare. Tag option: `index:"on,natural"`
+	NaturalOrder StringOption = 1 << iota
+
+	// Lowercase indexes the values of a string field in lower case, the query values of `==`, `in_array`, `like`
+	// and the other string queries are lowercased as well, so that `Name == "Vicky"` matches `vicky`.
+	// Tag option: `index:"on,lowercase"`
+	Lowercase
+)
+
+// normalize applies the normalizer of a string field to a value, at index time and to the query values
+func (seg *Segment) normalize(fieldID uint32, term string) string {
+	if seg.stringOptions[fieldID]&Lowercase != 0 {
+		return strings.ToLower(term)
+	}
+	return term
+}
+
+// SetStringOptions 设置字符串字段的比较方式，需要在索引该字段之前设置
+func (seg *Segment) SetStringOptions(field string, opts StringOption) {
+	seg.stringOptions[seg.fieldID(field)] = opts
+}
+
+// processNumberFields indexes an int64 or float64 value into the range index. NaN isn't comparable to any
+// number, so it isn't indexed: NaN values only ever match negated queries such as `!=`.
+func (seg *Segment) processNumberFields(inDocID uint32, field string, term interface{}) error {
+	if f, ok := term.(float64); ok && math.IsNaN(f) {
+		return nil
+	}
 	fieldID := seg.fieldID(field)
 
 	// fields = append(fields, &IndexableField{InternalDocId: docID, FieldID: fieldID, Term: term, TermID: termID})
-	if _, ok := seg.rangePostings[fieldID]; ok {
-		filedPostings := seg.rangePostings[fieldID]
-		RangePostingAdd(&filedPostings, term, inDocID)
-	} else {
-		filedPostings := NewRangePostingList()
-		RangePostingAdd(&filedPostings, term, inDocID)
+	filedPostings, ok := seg.rangePostings[fieldID]
+	if !ok {
+		list := NewRangePostingList()
+		filedPostings = &list
 		seg.rangePostings[fieldID] = filedPostings
 	}
+	if err := filedPostings.Add(term, inDocID); err != nil {
+		return err
+	}
+	doc := seg.indexed(inDocID)
+	doc.ranges = append(doc.ranges, docRange{fieldID, term})
+	return nil
+}
+
+// the range of the times indexed and compared as unix nano timestamps, UnixNano is undefined beyond it
+var (
+	minIndexTime = time.Unix(0, math.MinInt64).UTC() // 1677-09-21T00:12:43.145224192Z
+	maxIndexTime = time.Unix(0, math.MaxInt64).UTC() // 2262-04-11T23:47:16.854775807Z
+)
+
+// unixNano returns the unix nano timestamp a time is indexed and compared as, a time out of the range of the
+// timestamps, such as a `9999-12-31` sentinel, is an error rather than a wrapped around timestamp
+func unixNano(t time.Time) (int64, error) {
+	if t.Before(minIndexTime) || t.After(maxIndexTime) {
+		return 0, fmt.Errorf("time %s is out of the indexable range %s to %s", t.Format(time.RFC3339Nano),
+			minIndexTime.Format(time.RFC3339Nano), maxIndexTime.Format(time.RFC3339Nano))
+	}
+	return t.UnixNano(), nil
+}
+
+func (seg *Segment) processBoolField(inDocID uint32, field string, val bool) {
+	fieldID := seg.fieldID(field)
+
+	filedPostings, ok := seg.boolPostings[fieldID]
+	if !ok {
+		filedPostings = NewBoolPostingList()
+		seg.boolPostings[fieldID] = filedPostings
+	}
+	filedPostings.Add(val, inDocID)
+	doc := seg.indexed(inDocID)
+	doc.bools = append(doc.bools, fieldID)
 }
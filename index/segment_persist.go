@@ -0,0 +1,467 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/blevesearch/mmap-go"
+	"github.com/blevesearch/vellum"
+)
+
+// segment file layout, all integers are little endian:
+//
+//	magic "PANS" | version uint32
+//	fieldIdInt | termIdInc | docIdInc                          uint32 each
+//	fields:   count, (name, fieldID)...
+//	docs:     count, (internal id, external id)...
+//	full doc ids bitmap
+//	term dictionaries: count, (fieldID, FST bytes)...
+//	postings: count, (termID, term frequency, bitmap)...
+//	ranges:   count, (fieldID, number kind uint8, count, (number uint64, bitmap)...)...
+//	bools:    count, (fieldID, trues bitmap, falses bitmap)...  since version 2
+//	analyzers: count, (fieldID, analyzer name)...               since version 3
+//	term freqs: count, (termID, count, (internal id, freq)...)...  since version 4
+//	field lengths: count, (fieldID, count, (internal id, length)...)... since version 4
+//	string options: count, (fieldID, options uint32)...          since version 5
+//	crc32 (IEEE) of all the preceding bytes
+//
+// strings, FSTs and bitmaps are written as a uint32 length followed by the bytes.
+//
+// Segments are always written in the latest version, all the versions can be loaded: a section an older version
+// lacks is one the segment couldn't hold anything for when it was written (bool fields came with version 2, text
+// fields with version 3, string options with version 5), except the term frequencies and field lengths BM25 scores
+// match() with, so a version 3 segment holding text fields is rejected and must be rebuilt from the documents.
+const (
+	segmentMagic         = "PANS"
+	segmentFormatVersion = uint32(5)
+)
+
+var ErrSegmentCorrupted = errors.New("segment file corrupted")
+
+// WriteTo serializes the segment into a versioned binary format, implements io.WriterTo
+func (seg *Segment) WriteTo(w io.Writer) (int64, error) {
+	sw := &segmentWriter{w: w, crc: crc32.NewIEEE()}
+	sw.bytes([]byte(segmentMagic))
+	sw.uint32(segmentFormatVersion)
+	sw.uint32(seg.fieldIdInt)
+	sw.uint32(seg.termIdInc)
+	sw.uint32(seg.docIdInc)
+
+	fields := make([]string, 0, len(seg.fieldToFieldId))
+	for field := range seg.fieldToFieldId {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	sw.uint32(uint32(len(fields)))
+	for _, field := range fields {
+		sw.string(field)
+		sw.uint32(seg.fieldToFieldId[field])
+	}
+
+	sw.uint32(uint32(len(seg.docIDInternalToExternal)))
+	for _, inDocID := range sortedKeys(seg.docIDInternalToExternal) {
+		sw.uint32(inDocID)
+		sw.string(seg.docIDInternalToExternal[inDocID])
+	}
+	sw.bitmap(seg.fullDocIDBits)
+
+	sw.uint32(uint32(len(seg.termDicBytes)))
+	for _, fieldID := range sortedKeys(seg.termDicBytes) {
+		sw.uint32(fieldID)
+		sw.lenBytes(seg.termDicBytes[fieldID])
+	}
+
+	sw.uint32(uint32(len(seg.postings)))
+	for _, termID := range sortedKeys(seg.postings) {
+		postingList := seg.postings[termID]
+		sw.uint32(termID)
+		sw.uint32(postingList.TermFrequency)
+		sw.bitmap(postingList.Postings())
+	}
+
+	sw.uint32(uint32(len(seg.rangePostings)))
+	for _, fieldID := range sortedKeys(seg.rangePostings) {
+		fieldPostings := seg.rangePostings[fieldID]
+		sw.uint32(fieldID)
+		sw.byte(uint8(fieldPostings.numberKind))
+		sw.uint32(uint32(fieldPostings.rangePosting.Len()))
+		fieldPostings.rangePosting.Scan(func(item Item) bool {
+			switch num := item.numeric.(type) {
+			case int64:
+				sw.uint64(uint64(num))
+			case uint64:
+				sw.uint64(num)
+			case float64:
+				sw.uint64(math.Float64bits(num))
+			}
+			sw.bitmap(item.postings)
+			return true
+		})
+	}
+
+	sw.uint32(uint32(len(seg.boolPostings)))
+	for _, fieldID := range sortedKeys(seg.boolPostings) {
+		fieldPostings := seg.boolPostings[fieldID]
+		sw.uint32(fieldID)
+		sw.bitmap(fieldPostings.trues)
+		sw.bitmap(fieldPostings.falses)
+	}
+
+	sw.uint32(uint32(len(seg.analyzers)))
+	for _, fieldID := range sortedKeys(seg.analyzers) {
+		sw.uint32(fieldID)
+		sw.string(seg.analyzers[fieldID].name)
+	}
+	sw.docCounts(seg.termFreqs)
+	sw.docCounts(seg.fieldLengths)
+	sw.uint32(uint32(len(seg.stringOptions)))
+	for _, fieldID := range sortedKeys(seg.stringOptions) {
+		sw.uint32(fieldID)
+		sw.uint32(uint32(seg.stringOptions[fieldID]))
+	}
+
+	if sw.err == nil {
+		sum := sw.crc.Sum32()
+		sw.uint32(sum)
+	}
+	return sw.n, sw.err
+}
+
+// OpenSegment opens a segment file written by `Segment.WriteTo`. The file is memory mapped and the term
+// dictionaries (FSTs) are read from the mapping directly, the segment must be closed to release it.
+func OpenSegment(path string) (*Segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := mmap.Map(f, mmap.RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("mmap segment file:%s failed: %v", path, err)
+	}
+
+	seg, err := LoadSegment(data)
+	if err != nil {
+		data.Unmap()
+		return nil, err
+	}
+	seg.mmap = data
+	return seg, nil
+}
+
+// LoadSegment loads a segment from the bytes written by `Segment.WriteTo`. The FSTs refer to `data`, which must
+// not be modified while the segment is in use.
+func LoadSegment(data []byte) (*Segment, error) {
+	if len(data) < len(segmentMagic)+8 || string(data[:len(segmentMagic)]) != segmentMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrSegmentCorrupted)
+	}
+	payload := data[:len(data)-4]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrSegmentCorrupted)
+	}
+
+	sr := &segmentReader{data: payload, off: len(segmentMagic)}
+	version := sr.uint32()
+	if version < 1 || version > segmentFormatVersion {
+		return nil, fmt.Errorf("unsupported segment format version:%d, expected 1 to %d", version, segmentFormatVersion)
+	}
+
+	seg := NewSegment(0)
+	seg.fieldIdInt = sr.uint32()
+	seg.termIdInc = sr.uint32()
+	seg.docIdInc = sr.uint32()
+
+	for n := sr.uint32(); n > 0 && sr.err == nil; n-- {
+		field := sr.string()
+		seg.fieldToFieldId[field] = sr.uint32()
+	}
+	fieldNames := make(map[uint32]string, len(seg.fieldToFieldId))
+	for field, fieldID := range seg.fieldToFieldId {
+		fieldNames[fieldID] = field
+	}
+
+	for n := sr.uint32(); n > 0 && sr.err == nil; n-- {
+		inDocID := sr.uint32()
+		externalID := sr.string()
+		seg.docIDInternalToExternal[inDocID] = externalID
+		seg.docIDExternalToInternal[externalID] = inDocID
+	}
+	seg.fullDocIDBits = sr.bitmap()
+
+	for n := sr.uint32(); n > 0 && sr.err == nil; n-- {
+		fieldID := sr.uint32()
+		dic := sr.lenBytes()
+		if sr.err != nil {
+			break
+		}
+
+		termDictionary, err := vellum.Load(dic)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading term dictionary: err:%v", err)
+		}
+		iField, err := loadIndexableField(fieldNames[fieldID], fieldID, termDictionary)
+		if err != nil {
+			return nil, err
+		}
+		seg.termDicBytes[fieldID] = dic
+		seg.termDicFstCache[fieldID] = termDictionary
+		seg.fieldsTermDic[fieldID] = iField
+	}
+
+	for n := sr.uint32(); n > 0 && sr.err == nil; n-- {
+		termID := sr.uint32()
+		termFrequency := sr.uint32()
+		seg.postings[termID] = TermPostingList{termFrequency, sr.bitmap()}
+	}
+
+	for n := sr.uint32(); n > 0 && sr.err == nil; n-- {
+		fieldID := sr.uint32()
+		list := NewRangePostingList()
+		list.numberKind = reflect.Kind(sr.byte())
+		for m := sr.uint32(); m > 0 && sr.err == nil; m-- {
+			item := Item{kind: list.numberKind}
+			switch num := sr.uint64(); list.numberKind {
+			case reflect.Int64:
+				item.numeric = int64(num)
+			case reflect.Uint64:
+				item.numeric = num
+			case reflect.Float64:
+				item.numeric = math.Float64frombits(num)
+			default:
+				return nil, fmt.Errorf("%w: unknown number kind:%s", ErrSegmentCorrupted, list.numberKind)
+			}
+			item.postings = sr.bitmap()
+			list.rangePosting.Set(item)
+		}
+		seg.rangePostings[fieldID] = &list
+	}
+
+	if version >= 2 {
+		for n := sr.uint32(); n > 0 && sr.err == nil; n-- {
+			fieldID := sr.uint32()
+			seg.boolPostings[fieldID] = &BoolPostingList{trues: sr.bitmap(), falses: sr.bitmap()}
+		}
+	}
+
+	if version >= 3 {
+		for n := sr.uint32(); n > 0 && sr.err == nil; n-- {
+			fieldID, name := sr.uint32(), sr.string()
+			if sr.err != nil {
+				break
+			}
+			analyzer, err := GetAnalyzer(name)
+			if err != nil {
+				return nil, fmt.Errorf("field:%s %v", fieldNames[fieldID], err)
+			}
+			seg.analyzers[fieldID] = fieldAnalyzer{name: name, analyzer: analyzer}
+		}
+	}
+	if version >= 4 {
+		seg.termFreqs = sr.docCounts()
+		seg.fieldLengths = sr.docCounts()
+	} else if len(seg.analyzers) > 0 && sr.err == nil {
+		return nil, fmt.Errorf("segment format version:%d lacks the term frequencies of its text fields, rebuild it", version)
+	}
+	if version >= 5 {
+		for n := sr.uint32(); n > 0 && sr.err == nil; n-- {
+			fieldID, opts := sr.uint32(), sr.uint32()
+			seg.stringOptions[fieldID] = StringOption(opts)
+		}
+	}
+
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if sr.off != len(payload) {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrSegmentCorrupted, len(payload)-sr.off)
+	}
+	return seg, nil
+}
+
+// Close releases the memory mapping of a segment opened by `OpenSegment`, the segment must not be used afterwards
+func (seg *Segment) Close() error {
+	if seg.mmap == nil {
+		return nil
+	}
+
+	err := seg.mmap.Unmap()
+	seg.mmap = nil
+	return err
+}
+
+// loadIndexableField rebuilds the term to term id map of a field from its FST
+func loadIndexableField(field string, fieldID uint32, fst *vellum.FST) (*IndexableField, error) {
+	iField := NewIndexableField(field, fieldID)
+	itr, err := fst.Iterator(nil, nil)
+	for ; err == nil; err = itr.Next() {
+		term, termID := itr.Current()
+		iField.termToTermID[string(term)] = uint32(termID)
+	}
+	if err != vellum.ErrIteratorDone {
+		return nil, fmt.Errorf("failed iterating term dictionary of field:%s: %v", field, err)
+	}
+
+	return iField, nil
+}
+
+func sortedKeys[V any](m map[uint32]V) []uint32 {
+	keys := make([]uint32, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// segmentWriter writes the segment file, keeping the first error, the count of written bytes and the checksum
+type segmentWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	n   int64
+	err error
+	buf [8]byte
+}
+
+func (sw *segmentWriter) bytes(b []byte) {
+	if sw.err != nil {
+		return
+	}
+
+	n, err := sw.w.Write(b)
+	sw.n += int64(n)
+	sw.crc.Write(b[:n])
+	sw.err = err
+}
+
+func (sw *segmentWriter) byte(b byte) {
+	sw.bytes([]byte{b})
+}
+
+func (sw *segmentWriter) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(sw.buf[:4], v)
+	sw.bytes(sw.buf[:4])
+}
+
+func (sw *segmentWriter) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(sw.buf[:8], v)
+	sw.bytes(sw.buf[:8])
+}
+
+func (sw *segmentWriter) lenBytes(b []byte) {
+	sw.uint32(uint32(len(b)))
+	sw.bytes(b)
+}
+
+func (sw *segmentWriter) string(s string) {
+	sw.lenBytes([]byte(s))
+}
+
+func (sw *segmentWriter) bitmap(bm *roaring.Bitmap) {
+	buff := bytes.NewBuffer(make([]byte, 0, bm.GetSerializedSizeInBytes()))
+	if _, err := bm.WriteTo(buff); err != nil && sw.err == nil {
+		sw.err = err
+	}
+	sw.lenBytes(buff.Bytes())
+}
+
+// docCounts writes a map of id --> internal doc id --> count
+func (sw *segmentWriter) docCounts(m map[uint32]map[uint32]uint32) {
+	sw.uint32(uint32(len(m)))
+	for _, id := range sortedKeys(m) {
+		sw.uint32(id)
+		sw.uint32(uint32(len(m[id])))
+		for _, inDocID := range sortedKeys(m[id]) {
+			sw.uint32(inDocID)
+			sw.uint32(m[id][inDocID])
+		}
+	}
+}
+
+// segmentReader reads the segment file, once an error occurred all reads return zero values
+type segmentReader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (sr *segmentReader) next(n int) []byte {
+	if sr.err != nil {
+		return nil
+	}
+	if n < 0 || sr.off+n > len(sr.data) {
+		sr.err = fmt.Errorf("%w: unexpected end of file", ErrSegmentCorrupted)
+		return nil
+	}
+
+	b := sr.data[sr.off : sr.off+n]
+	sr.off += n
+	return b
+}
+
+func (sr *segmentReader) byte() byte {
+	if b := sr.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (sr *segmentReader) uint32() uint32 {
+	if b := sr.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (sr *segmentReader) uint64() uint64 {
+	if b := sr.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (sr *segmentReader) lenBytes() []byte {
+	return sr.next(int(sr.uint32()))
+}
+
+func (sr *segmentReader) string() string {
+	return string(sr.lenBytes())
+}
+
+// bitmap decodes a copy of the bitmap, so it stays valid once the file is unmapped
+func (sr *segmentReader) bitmap() *roaring.Bitmap {
+	bm := roaring.New()
+	b := sr.lenBytes()
+	if sr.err != nil {
+		return bm
+	}
+
+	if err := bm.UnmarshalBinary(b); err != nil {
+		sr.err = fmt.Errorf("%w: %v", ErrSegmentCorrupted, err)
+	}
+	return bm
+}
+
+func (sr *segmentReader) docCounts() map[uint32]map[uint32]uint32 {
+	m := make(map[uint32]map[uint32]uint32)
+	for n := sr.uint32(); n > 0 && sr.err == nil; n-- {
+		id := sr.uint32()
+		counts := make(map[uint32]uint32)
+		for c := sr.uint32(); c > 0 && sr.err == nil; c-- {
+			inDocID := sr.uint32()
+			counts[inDocID] = sr.uint32()
+		}
+		m[id] = counts
+	}
+	return m
+}
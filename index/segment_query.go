@@ -1,15 +1,20 @@
 package index
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"go/token"
+	goregexp "regexp"
+	"strings"
+	"sync"
 
 	"github.com/araddon/gou"
 	"github.com/araddon/qlbridge/value"
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/blevesearch/vellum"
+	"github.com/blevesearch/vellum/levenshtein"
 	"github.com/blevesearch/vellum/regexp"
 )
 
@@ -20,8 +25,14 @@ type Query interface {
 type QType int
 
 const (
-	TypeRegExQuery QType = 10 // 正则匹配
-	TypeTermQuery  QType = 11 // 词项精确匹配
+	TypeRegExQuery     QType = 10 // 正则匹配
+	TypeTermQuery      QType = 11 // 词项精确匹配
+	TypeBoolQuery      QType = 12 // 布尔值匹配
+	TypeMatchQuery     QType = 13 // 文本字段分词匹配
+	TypePrefixQuery    QType = 14 // 前缀匹配
+	TypeWildcardQuery  QType = 15 // 通配符匹配
+	TypeFuzzyQuery     QType = 16 // 编辑距离模糊匹配
+	TypeTermRangeQuery QType = 17 // 字符串范围查询
 
 	TypeRangeEQQuery QType = QType(token.EQL) // 范围查询:=
 	TypeRangeLEQuery QType = QType(token.LEQ) // 范围查询:<=
@@ -52,7 +63,7 @@ func (q *QueryBuilder) op(or bool, queries ...Query) *QueryBuilder {
 	currentOps := q.ops
 	op := func() (*SearchResults, error) {
 		// TODO and all the queries together.
-		res := &SearchResults{roaring.New(), nil}
+		res := &SearchResults{roaring.New(), nil, nil}
 		firstRun := true
 		for _, query := range queries {
 			var results *SearchResults
@@ -66,6 +77,30 @@ func (q *QueryBuilder) op(or bool, queries ...Query) *QueryBuilder {
 				tmpq := query.(*TermQuery)
 				results, err = q.seg.QueryTerm(q.ctx, tmpq)
 
+			case TypeBoolQuery:
+				tmpq := query.(*BoolQuery)
+				results, err = q.seg.QueryBool(q.ctx, tmpq)
+
+			case TypeMatchQuery:
+				tmpq := query.(*MatchQuery)
+				results, err = q.seg.QueryMatch(q.ctx, tmpq)
+
+			case TypePrefixQuery:
+				tmpq := query.(*PrefixQuery)
+				results, err = q.seg.QueryPrefix(q.ctx, tmpq)
+
+			case TypeWildcardQuery:
+				tmpq := query.(*WildcardQuery)
+				results, err = q.seg.QueryWildcard(q.ctx, tmpq)
+
+			case TypeFuzzyQuery:
+				tmpq := query.(*FuzzyQuery)
+				results, err = q.seg.QueryFuzzy(q.ctx, tmpq)
+
+			case TypeTermRangeQuery:
+				tmpq := query.(*TermRangeQuery)
+				results, err = q.seg.QueryTermRange(q.ctx, tmpq)
+
 			case TypeRangeEQQuery, TypeRangeLEQuery, TypeRangeLTQuery, TypeRangeGEQuery, TypeRangeGTQuery:
 				tmpq := query.(*RangeQuery)
 				results, err = q.seg.QueryRange(q.ctx, tmpq)
@@ -76,12 +111,12 @@ func (q *QueryBuilder) op(or bool, queries ...Query) *QueryBuilder {
 				return nil, err
 			}
 			if firstRun {
-				res.internalDocIds.Or(results.internalDocIds) // Add all of them on the first loop
+				res.Or(results) // Add all of them on the first loop
 				firstRun = false
 			} else if or { // or
-				res.internalDocIds.Or(results.internalDocIds)
+				res.Or(results)
 			} else { // and
-				res.internalDocIds.And(results.internalDocIds)
+				res.And(results)
 			}
 		}
 
@@ -93,7 +128,7 @@ func (q *QueryBuilder) op(or bool, queries ...Query) *QueryBuilder {
 			if err != nil {
 				return nil, err
 			}
-			res.internalDocIds.Or(children.internalDocIds)
+			res.Or(children)
 		}
 		return res, nil
 	}
@@ -134,6 +169,17 @@ func GetExternalIDs(seg *Segment, internalDocIds *roaring.Bitmap) ([]string, err
 }
 
 func NewQuery(qtype QType, field string, val value.Value) (Query, error) {
+	switch v := val.(type) { // times are indexed as unix nano timestamps, and durations as nanoseconds
+	case value.TimeValue:
+		nano, err := unixNano(v.Val())
+		if err != nil {
+			return nil, fmt.Errorf("filed:`%s` %v", field, err)
+		}
+		val = value.NewIntValue(nano)
+	case DurationValue:
+		val = value.NewIntValue(int64(v.Val()))
+	}
+
 	switch qtype {
 	case TypeRegExQuery:
 		if val.Type() == value.StringType {
@@ -141,19 +187,41 @@ func NewQuery(qtype QType, field string, val value.Value) (Query, error) {
 		} else {
 			return nil, fmt.Errorf("filed:`%s` not surport `like` query, only accepts string fields", field)
 		}
+	case TypePrefixQuery, TypeWildcardQuery, TypeFuzzyQuery:
+		if val.Type() != value.StringType {
+			return nil, fmt.Errorf("filed:`%s` not surport `%s` query, only accepts string values", field, qtypeFuncNames[qtype])
+		}
+		switch term := val.Value().(string); qtype {
+		case TypePrefixQuery:
+			return &PrefixQuery{field, term}, nil
+		case TypeWildcardQuery:
+			return &WildcardQuery{field, term}, nil
+		default:
+			return &FuzzyQuery{field, term, 1}, nil
+		}
+	case TypeMatchQuery:
+		if val.Type() != value.StringType {
+			return nil, fmt.Errorf("filed:`%s` not surport `match` query, only accepts string values", field)
+		}
+		return &MatchQuery{FieldName: field, Text: val.Value().(string)}, nil
 	case TypeTermQuery:
 		if val.Type() == value.StringType {
 			return &TermQuery{field, val.Value().(string)}, nil
-		} else if val.Type() == value.IntType {
-			return &RangeQuery{field, val.Value().(int64), TypeRangeEQQuery}, nil
+		} else if val.Type() == value.IntType || val.Type() == UintType || val.Type() == value.NumberType {
+			return &RangeQuery{field, val.Value(), TypeRangeEQQuery}, nil
+		} else if val.Type() == value.BoolType {
+			return &BoolQuery{field, val.Value().(bool)}, nil
 		} else {
-			return nil, fmt.Errorf("filed:`%s` not surport `=` and `in_array` query, only accept int and string fields", field)
+			return nil, fmt.Errorf("filed:`%s` not surport `=` and `in_array` query, only accept number, time, string and bool fields", field)
 		}
 	case TypeRangeEQQuery, TypeRangeLEQuery, TypeRangeLTQuery, TypeRangeGEQuery, TypeRangeGTQuery:
-		if val.Type() != value.IntType {
-			return nil, fmt.Errorf("filed:`%s` not surport `%s` query, only accept int fields", field, token.Token(qtype))
+		if val.Type() == value.StringType && qtype != TypeRangeEQQuery {
+			return &TermRangeQuery{field, val.Value().(string), qtype}, nil
+		}
+		if val.Type() != value.IntType && val.Type() != UintType && val.Type() != value.NumberType {
+			return nil, fmt.Errorf("filed:`%s` not surport `%s` query, only accept number, time and string fields", field, token.Token(qtype))
 		}
-		return &RangeQuery{field, val.Value().(int64), qtype}, nil
+		return &RangeQuery{field, val.Value(), qtype}, nil
 	}
 
 	return nil, fmt.Errorf("unsupported query type: %v for field:%s", qtype, field)
@@ -163,6 +231,10 @@ type SearchResults struct {
 	internalDocIds *roaring.Bitmap
 
 	ExternalDocIDs []string
+
+	// relevance scores of the docs matched by scoring queries such as `match()`, by internal doc id. Scores of the
+	// docs matching both sides of an `&&` or an `||` add up, a negated result isn't scored.
+	scores map[uint32]float64
 }
 
 type RegExTermQuery struct {
@@ -177,7 +249,6 @@ func (seg *Segment) QueryRegEx(ctx context.Context, query *RegExTermQuery) (*Sea
 	field := query.FieldName
 	regEx := query.RegEx
 
-	var err error
 	fieldId, ok := seg.fieldToFieldId[field]
 	if !ok {
 		return nil, fmt.Errorf("no field-id found for field: %v", field)
@@ -185,34 +256,362 @@ func (seg *Segment) QueryRegEx(ctx context.Context, query *RegExTermQuery) (*Sea
 
 	termDictionary, ok := seg.termDicFstCache[fieldId]
 	if !ok {
-		tbytes, ok := seg.termDicBytes[fieldId]
-		if !ok {
-			return nil, fmt.Errorf("no term dictionary found for field: %v", field)
-		}
-		termDictionary, err = vellum.Load(tbytes)
-		if err != nil {
-			return nil, fmt.Errorf("failed loading term dictionary: err:%v", err)
-		}
-		seg.termDicFstCache[fieldId] = termDictionary
+		return seg.noIndexFound(fieldId, field)
+	}
+	if seg.stringOptions[fieldId]&Lowercase != 0 { // the terms are lowercased, so is anything the pattern matches
+		regEx = "(?i)" + regEx
 	}
 	//
 	// Query the Term Dic
 	//
-	r, err := regexp.New(regEx)
+	r, err := cachedAutomaton(ctx, "regex\x00"+regEx, func() (vellum.Automaton, error) {
+		return regexp.New(regEx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return seg.searchTermDic(ctx, termDictionary, r, nil, nil), nil
+}
+
+// automatonCache holds the automata compiled for a prepared query, automata are immutable once built so they are
+// shared by all the executions of the query, whatever the segment
+type automatonCache struct {
+	mu sync.RWMutex
+	m  map[string]vellum.Automaton
+}
+
+type automataKey struct{}
+
+// withAutomata returns a context the segment queries compile their automata through, see cachedAutomaton
+func withAutomata(ctx context.Context, cache *automatonCache) context.Context {
+	return context.WithValue(ctx, automataKey{}, cache)
+}
+
+// cachedAutomaton returns the automaton of the key from the cache of the context, it's built and cached on the first
+// use. Without cache the automaton is built every time.
+func cachedAutomaton(ctx context.Context, key string, build func() (vellum.Automaton, error)) (vellum.Automaton, error) {
+	cache, _ := ctx.Value(automataKey{}).(*automatonCache)
+	if cache == nil {
+		return build()
+	}
+
+	cache.mu.RLock()
+	aut, ok := cache.m[key]
+	cache.mu.RUnlock()
+	if ok {
+		return aut, nil
+	}
+
+	aut, err := build()
 	if err != nil {
 		return nil, err
 	}
+	cache.mu.Lock()
+	if cache.m == nil {
+		cache.m = make(map[string]vellum.Automaton)
+	}
+	cache.m[key] = aut
+	cache.mu.Unlock()
+	return aut, nil
+}
 
-	var res *SearchResults = &SearchResults{roaring.New(), nil}
-	itr, err := termDictionary.Search(r, nil, nil)
+// searchTermDic ORs the postings of the terms in [start, end) accepted by the automaton, a nil automaton accepts
+// all the terms and a nil bound is unbounded
+func (seg *Segment) searchTermDic(ctx context.Context, termDictionary *vellum.FST, aut vellum.Automaton, start, end []byte) *SearchResults {
+	var res *SearchResults = &SearchResults{roaring.New(), nil, nil}
+	expanded := expandedTerms(ctx)
+	var itr *vellum.FSTIterator
+	var err error
+	if aut == nil {
+		itr, err = termDictionary.Iterator(start, end)
+	} else {
+		itr, err = termDictionary.Search(aut, start, end)
+	}
 	for ; err == nil; err = itr.Next() {
-		_, termID := itr.Current()
+		term, termID := itr.Current()
+		if expanded != nil {
+			expanded[string(term)] = struct{}{}
+		}
 		postingList := seg.postings[uint32(termID)]
 		postings := postingList.Postings()
 		res.internalDocIds.Or(postings)
 	}
 
-	return res, nil
+	return res
+}
+
+// qtypeFuncNames are the names of the DSL functions of the term dictionary queries, for the error messages
+var qtypeFuncNames = map[QType]string{
+	TypePrefixQuery:   "prefix",
+	TypeWildcardQuery: "wildcard",
+	TypeFuzzyQuery:    "fuzzy",
+}
+
+// PrefixQuery 匹配以 Prefix 开头的词项，通过 FST 的区间迭代实现，不需要遍历整个词典
+type PrefixQuery struct {
+	FieldName string
+	Prefix    string
+}
+
+func (q *PrefixQuery) Type() QType {
+	return TypePrefixQuery
+}
+
+func (seg *Segment) QueryPrefix(ctx context.Context, query *PrefixQuery) (*SearchResults, error) {
+	field := query.FieldName
+	fieldId, ok := seg.fieldToFieldId[field]
+	if !ok {
+		return nil, fmt.Errorf("no field-id found for field: %v", field)
+	}
+
+	termDictionary, ok := seg.termDicFstCache[fieldId]
+	if !ok {
+		return seg.noIndexFound(fieldId, field)
+	}
+
+	prefix := seg.normalize(fieldId, query.Prefix)
+	var start []byte
+	if prefix != "" {
+		start = []byte(prefix)
+	}
+	return seg.searchTermDic(ctx, termDictionary, nil, start, prefixEnd(prefix)), nil
+}
+
+// prefixEnd returns the smallest key greater than all the keys starting with prefix, nil if there is none
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// WildcardQuery 通配符匹配，`?` 匹配任意单个字符，`*` 匹配任意个字符，`\` 转义其后的字符，需要匹配整个词项
+type WildcardQuery struct {
+	FieldName string
+	Pattern   string
+}
+
+func (q *WildcardQuery) Type() QType {
+	return TypeWildcardQuery
+}
+
+func (seg *Segment) QueryWildcard(ctx context.Context, query *WildcardQuery) (*SearchResults, error) {
+	return seg.QueryRegEx(ctx, &RegExTermQuery{query.FieldName, wildcardToRegEx(query.Pattern)})
+}
+
+// wildcardToRegEx converts a wildcard pattern to a regular expression, the other chars are quoted
+func wildcardToRegEx(pattern string) string {
+	var b strings.Builder
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(goregexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '?':
+			b.WriteString(".")
+		case r == '*':
+			b.WriteString(".*")
+		default:
+			b.WriteString(goregexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped { // a trailing backslash matches itself
+		b.WriteString(goregexp.QuoteMeta("\\"))
+	}
+	return b.String()
+}
+
+// MaxFuzziness 模糊匹配支持的最大编辑距离，编辑距离越大自动机越大，匹配的词项也越多
+const MaxFuzziness = 2
+
+// FuzzyQuery 匹配与 Term 的编辑距离（Damerau-Levenshtein，相邻字符交换计为一次编辑）不超过 Fuzziness 的词项，
+// 如 `vikcy` 与 `vicky` 的编辑距离为 1
+type FuzzyQuery struct {
+	FieldName string
+	Term      string
+	Fuzziness uint8
+}
+
+func (q *FuzzyQuery) Type() QType {
+	return TypeFuzzyQuery
+}
+
+func (seg *Segment) QueryFuzzy(ctx context.Context, query *FuzzyQuery) (*SearchResults, error) {
+	field := query.FieldName
+	fieldId, ok := seg.fieldToFieldId[field]
+	if !ok {
+		return nil, fmt.Errorf("no field-id found for field: %v", field)
+	}
+
+	termDictionary, ok := seg.termDicFstCache[fieldId]
+	if !ok {
+		return seg.noIndexFound(fieldId, field)
+	}
+
+	term := seg.normalize(fieldId, query.Term)
+	dfa, err := cachedAutomaton(ctx, fmt.Sprintf("fuzzy\x00%d\x00%s", query.Fuzziness, term), func() (vellum.Automaton, error) {
+		return buildLevenshteinDfa(term, query.Fuzziness)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return seg.searchTermDic(ctx, termDictionary, dfa, nil, nil), nil
+}
+
+// levenshteinBuilders are expensive to build, they are built once per fuzziness and shared by all the queries
+var levenshteinBuilders [MaxFuzziness + 1]struct {
+	once    sync.Once
+	builder *levenshtein.LevenshteinAutomatonBuilder
+	err     error
+}
+
+func buildLevenshteinDfa(term string, fuzziness uint8) (*levenshtein.DFA, error) {
+	if fuzziness > MaxFuzziness {
+		return nil, fmt.Errorf("fuzziness %d exceeds the max fuzziness %d", fuzziness, MaxFuzziness)
+	}
+
+	lb := &levenshteinBuilders[fuzziness]
+	lb.once.Do(func() {
+		lb.builder, lb.err = levenshtein.NewLevenshteinAutomatonBuilder(fuzziness, true)
+	})
+	if lb.err != nil {
+		return nil, lb.err
+	}
+	return lb.builder.BuildDfa(term, fuzziness)
+}
+
+// TermRangeQuery 字符串范围查询，默认按字节序比较，通过 FST 的区间迭代实现；设置了 NaturalOrder 的字段按自然序比较，
+// 需要遍历整个词典
+type TermRangeQuery struct {
+	FieldName string
+	Term      string
+	qtype     QType // one of the range query types but TypeRangeEQQuery
+}
+
+func (q *TermRangeQuery) Type() QType {
+	return TypeTermRangeQuery
+}
+
+func (seg *Segment) QueryTermRange(ctx context.Context, query *TermRangeQuery) (*SearchResults, error) {
+	field := query.FieldName
+	fieldId, ok := seg.fieldToFieldId[field]
+	if !ok {
+		return nil, fmt.Errorf("no field-id found for field: %v", field)
+	}
+	if _, ok := seg.analyzers[fieldId]; ok {
+		return nil, fmt.Errorf("filed:`%s` is a text field, not surport `%s` query", field, token.Token(query.qtype))
+	}
+
+	termDictionary, ok := seg.termDicFstCache[fieldId]
+	if !ok {
+		return seg.noIndexFound(fieldId, field)
+	}
+
+	bound := seg.normalize(fieldId, query.Term)
+	if seg.stringOptions[fieldId]&NaturalOrder != 0 {
+		res := &SearchResults{roaring.New(), nil, nil}
+		expanded := expandedTerms(ctx)
+		itr, err := termDictionary.Iterator(nil, nil)
+		for ; err == nil; err = itr.Next() {
+			term, termID := itr.Current()
+			if compareMatches(query.qtype, naturalCompare(string(term), bound)) {
+				if expanded != nil {
+					expanded[string(term)] = struct{}{}
+				}
+				res.internalDocIds.Or(seg.postings[uint32(termID)].Postings())
+			}
+		}
+		return res, nil
+	}
+
+	// bounds of the FST iteration, [start, end), the smallest key greater than a term is the term followed by 0
+	var start, end []byte
+	switch query.qtype {
+	case TypeRangeGEQuery:
+		start = []byte(bound)
+	case TypeRangeGTQuery:
+		start = append([]byte(bound), 0)
+	case TypeRangeLTQuery:
+		if bound == "" { // nothing is less than the empty string, and an empty end is unbounded
+			return &SearchResults{roaring.New(), nil, nil}, nil
+		}
+		end = []byte(bound)
+	case TypeRangeLEQuery:
+		end = append([]byte(bound), 0)
+	}
+	return seg.searchTermDic(ctx, termDictionary, nil, start, end), nil
+}
+
+// compareMatches reports whether the result of a comparison of a value with the bound satisfies the range query
+func compareMatches(qtype QType, c int) bool {
+	switch qtype {
+	case TypeRangeEQQuery:
+		return c == 0
+	case TypeRangeLEQuery:
+		return c <= 0
+	case TypeRangeLTQuery:
+		return c < 0
+	case TypeRangeGEQuery:
+		return c >= 0
+	case TypeRangeGTQuery:
+		return c > 0
+	}
+	return false
+}
+
+// naturalCompare compares two strings in natural order: runs of digits compare by their numeric values, so that
+// `2.10.0` > `2.9.0` and `file10` > `file9`, the other bytes compare as is. A version followed by a `-` is a semver
+// pre-release and sorts before the version itself: `1.0.0-rc.1` < `1.0.0`.
+func naturalCompare(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			si, sj := i, j
+			for ; i < len(a) && isDigit(a[i]); i++ {
+			}
+			for ; j < len(b) && isDigit(b[j]); j++ {
+			}
+			na, nb := strings.TrimLeft(a[si:i], "0"), strings.TrimLeft(b[sj:j], "0")
+			if c := cmp.Compare(len(na), len(nb)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			continue
+		}
+		if a[i] != b[j] {
+			return cmp.Compare(a[i], b[j])
+		}
+		i, j = i+1, j+1
+	}
+
+	switch {
+	case i == len(a) && j == len(b):
+		return 0
+	case i == len(a): // a is a prefix of b
+		if b[j] == '-' {
+			return 1
+		}
+		return -1
+	default:
+		if a[i] == '-' {
+			return -1
+		}
+		return 1
+	}
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
 }
 
 type TermQuery struct {
@@ -235,13 +634,18 @@ func (seg *Segment) QueryTerm(ctx context.Context, query *TermQuery) (*SearchRes
 
 	termDictionary, ok := seg.fieldsTermDic[fieldId]
 	if !ok {
-		return nil, fmt.Errorf("no term dictionary found for field: %v", field)
+		return seg.noIndexFound(fieldId, field)
 	}
 	//
 	// Query the Term Dic
 	//
+	if fa, ok := seg.analyzers[fieldId]; ok { // docs holding all the tokens of the query value
+		return seg.matchTokens(termDictionary, fa.analyzer.Analyze(term)), nil
+	}
+	term = seg.normalize(fieldId, term)
+
+	var res *SearchResults = &SearchResults{roaring.New(), nil, nil}
 	termID, ok := termDictionary.termToTermID[term]
-	var res *SearchResults = &SearchResults{roaring.New(), nil}
 	if !ok {
 		return res, nil
 	}
@@ -253,9 +657,92 @@ func (seg *Segment) QueryTerm(ctx context.Context, query *TermQuery) (*SearchRes
 	return res, nil
 }
 
+// MatchQuery 文本字段的分词匹配：查询文本使用字段的分析器切分为词元，匹配包含全部词元的文档，并按照 BM25 计算相关性得分
+type MatchQuery struct {
+	FieldName string
+	Text      string
+
+	stats *textStats // statistics of the field over all the segments of the index, the segment's own if nil
+}
+
+func (q *MatchQuery) Type() QType {
+	return TypeMatchQuery
+}
+
+func (seg *Segment) QueryMatch(ctx context.Context, query *MatchQuery) (*SearchResults, error) {
+	field := query.FieldName
+
+	fieldId, ok := seg.fieldToFieldId[field]
+	if !ok {
+		return nil, fmt.Errorf("no field-id found for field: %v", field)
+	}
+
+	fa, ok := seg.analyzers[fieldId]
+	if !ok {
+		return nil, fmt.Errorf("field:%s is not a text field, `match` needs an analyzer", field)
+	}
+
+	termDictionary, ok := seg.fieldsTermDic[fieldId]
+	if !ok { // no doc holds a value yet
+		return &SearchResults{roaring.New(), nil, nil}, nil
+	}
+
+	tokens := fa.analyzer.Analyze(query.Text)
+	res := seg.matchTokens(termDictionary, tokens)
+	stats := query.stats
+	if stats == nil {
+		stats = collectTextStats([]*Segment{seg}, roaring.New(), field, tokens)
+	}
+	seg.scoreBM25(res, fieldId, termDictionary, tokens, stats)
+	return res, nil
+}
+
+// matchTokens returns the docs holding all the tokens, no doc matches an empty list of tokens
+func (seg *Segment) matchTokens(termDictionary *IndexableField, tokens []Token) *SearchResults {
+	res := &SearchResults{roaring.New(), nil, nil}
+	for j, tok := range tokens {
+		termID, ok := termDictionary.termToTermID[tok.Term]
+		if !ok {
+			return &SearchResults{roaring.New(), nil, nil}
+		}
+
+		if j == 0 {
+			res.internalDocIds.Or(seg.postings[termID].Postings())
+		} else {
+			res.internalDocIds.And(seg.postings[termID].Postings())
+		}
+	}
+	return res
+}
+
+type BoolQuery struct {
+	FieldName string
+	Value     bool
+}
+
+func (q *BoolQuery) Type() QType {
+	return TypeBoolQuery
+}
+
+func (seg *Segment) QueryBool(ctx context.Context, query *BoolQuery) (*SearchResults, error) {
+	field := query.FieldName
+
+	fieldId, ok := seg.fieldToFieldId[field]
+	if !ok {
+		return nil, fmt.Errorf("no field-id found for field: %v", field)
+	}
+
+	fieldPostings, ok := seg.boolPostings[fieldId]
+	if !ok {
+		return seg.noIndexFound(fieldId, field)
+	}
+
+	return &SearchResults{fieldPostings.Postings(query.Value).Clone(), nil, nil}, nil
+}
+
 type RangeQuery struct {
 	FieldName string
-	Num       int64
+	Num       interface{} // int64, uint64 or float64
 	qtype     QType
 }
 
@@ -265,7 +752,6 @@ func (q *RangeQuery) Type() QType {
 
 func (seg *Segment) QueryRange(ctx context.Context, query *RangeQuery) (*SearchResults, error) {
 	field := query.FieldName
-	term := query.Num
 
 	fieldId, ok := seg.fieldToFieldId[field]
 	if !ok {
@@ -274,41 +760,72 @@ func (seg *Segment) QueryRange(ctx context.Context, query *RangeQuery) (*SearchR
 
 	fieldPostings, ok := seg.rangePostings[fieldId]
 	if !ok {
-		return nil, fmt.Errorf("no term dictionary found for field: %v", field)
+		return seg.noIndexFound(fieldId, field)
 	}
 
-	var res *SearchResults = &SearchResults{roaring.New(), nil}
-	switch query.qtype {
-	case TypeRangeEQQuery:
-		res.internalDocIds = RangePostingEqual(&fieldPostings, term)
-	case TypeRangeGEQuery:
-		res.internalDocIds = RangePostingGraterEqual(&fieldPostings, term)
-	case TypeRangeGTQuery:
-		res.internalDocIds = RangePostingGraterThan(&fieldPostings, term)
-	case TypeRangeLEQuery:
-		res.internalDocIds = RangePostingLessEqual(&fieldPostings, term)
-	case TypeRangeLTQuery:
-		res.internalDocIds = RangePostingLessThan(&fieldPostings, term)
+	return &SearchResults{fieldPostings.Query(query.qtype, query.Num), nil, nil}, nil
+}
+
+// noIndexFound handles a query on a field that has no index of the queried kind. If the field holds values of
+// another kind the query doesn't fit the field, otherwise the segment has no value for the field and nothing matches.
+func (seg *Segment) noIndexFound(fieldId uint32, field string) (*SearchResults, error) {
+	_, isTerm := seg.fieldsTermDic[fieldId]
+	_, isRange := seg.rangePostings[fieldId]
+	_, isBool := seg.boolPostings[fieldId]
+	if isTerm || isRange || isBool {
+		return nil, fmt.Errorf("no term dictionary found for field: %v", field)
 	}
 
-	return res, nil
+	return &SearchResults{roaring.New(), nil, nil}, nil
 }
 
 func (s *SearchResults) Not(seg *Segment) *SearchResults {
 	s.internalDocIds.Xor(seg.fullDocIDBits)
+	s.scores = nil
 	return s
 }
 
 func (s *SearchResults) And(o *SearchResults) *SearchResults {
 	s.internalDocIds.And(o.internalDocIds)
+	for inDocID := range s.scores {
+		if !s.internalDocIds.Contains(inDocID) {
+			delete(s.scores, inDocID)
+		}
+	}
+	for inDocID, score := range o.scores {
+		if s.internalDocIds.Contains(inDocID) {
+			s.addScore(inDocID, score)
+		}
+	}
+	return s
+}
+
+// AndNot removes the docs of o, the scores of o don't count
+func (s *SearchResults) AndNot(o *SearchResults) *SearchResults {
+	s.internalDocIds.AndNot(o.internalDocIds)
+	for inDocID := range s.scores {
+		if !s.internalDocIds.Contains(inDocID) {
+			delete(s.scores, inDocID)
+		}
+	}
 	return s
 }
 
 func (s *SearchResults) Or(o *SearchResults) *SearchResults {
 	s.internalDocIds.Or(o.internalDocIds)
+	for inDocID, score := range o.scores {
+		s.addScore(inDocID, score)
+	}
 	return s
 }
 
+func (s *SearchResults) addScore(inDocID uint32, score float64) {
+	if s.scores == nil {
+		s.scores = make(map[uint32]float64)
+	}
+	s.scores[inDocID] += score
+}
+
 func (s *SearchResults) String() string {
 	return s.internalDocIds.String()
 }
@@ -1,9 +1,15 @@
 package index_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"hash/fnv"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -56,6 +62,9 @@ func indexDoc(t *testing.T) *index.Segment {
 		default:
 			fieldvals["name.first"] = value.NewStringValue("default")
 		}
+		if i%7 == 0 {
+			fieldvals["bio"] = value.NewStringValue(fmt.Sprintf("The Story of %s, chapter %d", fieldvals["name.first"].ToString(), i%3))
+		}
 		if i == 101 || i == 100 {
 			fieldvals["name.last"] = value.NewStringValue("manning")
 		}
@@ -66,6 +75,10 @@ func indexDoc(t *testing.T) *index.Segment {
 		docs = append(docs, index.NewDocument(fmt.Sprintf("doc_number:%000d", i), fieldvals, now))
 	}
 	segment := index.NewSegment(100)
+	if err := segment.SetAnalyzer("bio", "english"); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	segment.SetStringOptions("doc_id", index.NaturalOrder)
 	err := segment.IndexDocuments(context.TODO(), docs)
 	if err != nil {
 		t.Fatalf("err:%v", err)
@@ -145,8 +158,24 @@ func TestQuery(t *testing.T) {
 			expr: `(age == 1 || age == 2) && name.first!="default"`,
 			want: roaring.BitmapOf(0, 1, 100, 101, 200, 201, 300, 301, 400, 401)},
 		{name: "num-like-err", expr: `like(age, 22)`, err: true, want: roaring.BitmapOf()},
-		{name: "str-eq-err", expr: `age>=1.5`, err: true, want: roaring.BitmapOf()},
-		{name: "str-range-err", expr: `name.first > "eric"`, err: true, want: roaring.BitmapOf()},
+		{
+			name: "int-float-gt",
+			expr: `age>48.5`,
+			want: roaring.BitmapOf(48, 49, 98, 99, 148, 149, 198, 199, 248, 249, 298, 299, 348, 349, 398, 399, 448, 449, 498, 499)},
+		{name: "int-float-eq", expr: `age==1.5`, want: roaring.BitmapOf()},
+		{name: "int-float-out-of-range", expr: `age<1e30 && age>=50`, want: roaring.BitmapOf(49, 99, 149, 199, 249, 299, 349, 399, 449, 499)},
+		{
+			name: "str-range-gt",
+			expr: `name.first > "eric"`,
+			want: roaring.BitmapOf(1, 3, 4, 5, 101, 103, 104, 105, 201, 203, 204, 205, 301, 303, 304, 305, 401, 403, 404, 405)},
+		{
+			name: "str-range-between",
+			expr: `name.first >= "j" && name.first < "jon"`,
+			want: roaring.BitmapOf(4, 5, 104, 105, 204, 205, 304, 305, 404, 405)},
+		{name: "str-range-le", expr: `name.last <= "smith"`, want: roaring.BitmapOf(100, 101, 102)},
+		{name: "str-range-num-field-err", expr: `age > "20"`, err: true, want: roaring.BitmapOf()},
+		{name: "str-range-natural", expr: `doc_id > "489" && doc_id <= "0495"`, want: roaring.BitmapOf(490, 491, 492, 493, 494, 495)},
+		{name: "str-range-text-err", expr: `bio > "story"`, err: true, want: roaring.BitmapOf()},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -174,3 +203,179 @@ func hash(s string) string {
 	key := h.Sum64()
 	return fmt.Sprintf("%v", key)
 }
+
+func TestSegment_WriteToOpen(t *testing.T) {
+	segment := indexDoc(t)
+	path := filepath.Join(t.TempDir(), "segment.pans")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if _, err := segment.WriteTo(f); err != nil {
+		t.Fatalf("Segment.WriteTo() err:%v", err)
+	}
+	f.Close()
+
+	opened, err := index.OpenSegment(path)
+	if err != nil {
+		t.Fatalf("OpenSegment() err:%v", err)
+	}
+	defer opened.Close()
+
+	for _, expr := range []string{
+		`name.first=="eric"`,
+		`like(name.last, "smit.*") || (name.first=="eric")`,
+		`!(name.first=="eric" && name.last=="manning") && name.first!="default"`,
+		`age >= 20 && age < 22`,
+		`in_array(age, []int{20, 21})`,
+		`bio == "the STORY of eric"`,
+		`bio == "chapter 2" && !(bio == "default")`,
+		`doc_id > "489" && name.first > "eric"`,
+	} {
+		want, err := index.DoQuery(expr, segment)
+		if err != nil {
+			t.Fatalf("DoQuery(%s) err:%v", expr, err)
+		}
+		got, err := index.DoQuery(expr, opened)
+		if err != nil {
+			t.Fatalf("DoQuery(%s) on opened segment err:%v", expr, err)
+		}
+		assert.Equalf(t, want.ExternalDocIDs, got.ExternalDocIDs, "DoQuery(%s) on opened segment", expr)
+	}
+
+	data, _ := os.ReadFile(path)
+	data[len(data)/2]++
+	if _, err := index.LoadSegment(data); !errors.Is(err, index.ErrSegmentCorrupted) {
+		t.Errorf("LoadSegment() of corrupted data err:%v, want ErrSegmentCorrupted", err)
+	}
+}
+
+func TestLoadSegment_OlderVersions(t *testing.T) {
+	seg := index.NewSegment(10)
+	docs := make([]index.Document, 0, 10)
+	for i := 0; i < 10; i++ {
+		docs = append(docs, index.NewDocument(fmt.Sprint(i), map[string]value.Value{
+			"name": value.NewStringValue(fmt.Sprintf("name-%d", i%3)),
+			"age":  value.NewIntValue(int64(20 + i)),
+		}, time.Now()))
+	}
+	if err := seg.IndexDocuments(context.Background(), docs); err != nil {
+		t.Fatalf("IndexDocuments() err:%v", err)
+	}
+
+	// an older version of the segment: the empty sections it lacks are dropped and the checksum recomputed
+	downgrade := func(seg *index.Segment, version uint32, emptySections int) []byte {
+		var buf bytes.Buffer
+		if _, err := seg.WriteTo(&buf); err != nil {
+			t.Fatalf("Segment.WriteTo() err:%v", err)
+		}
+		data := buf.Bytes()
+		payload := append([]byte(nil), data[:len(data)-4-4*emptySections]...)
+		for _, b := range data[len(payload) : len(data)-4] {
+			if b != 0 {
+				t.Fatalf("section of version %d isn't empty", version)
+			}
+		}
+		binary.LittleEndian.PutUint32(payload[4:], version)
+		return binary.LittleEndian.AppendUint32(payload, crc32.ChecksumIEEE(payload))
+	}
+
+	// version 1 lacks bools, analyzers, term freqs, field lengths and string options, version 2 all but bools
+	for version, emptySections := range map[uint32]int{1: 5, 2: 4} {
+		loaded, err := index.LoadSegment(downgrade(seg, version, emptySections))
+		if err != nil {
+			t.Fatalf("LoadSegment() of version %d err:%v", version, err)
+		}
+		for _, expr := range []string{`name == "name-1"`, `age >= 25 && !(name == "name-2")`, `like(name, "name-[01]")`} {
+			want, _ := index.DoQuery(expr, seg)
+			got, err := index.DoQuery(expr, loaded)
+			if err != nil {
+				t.Fatalf("DoQuery(%s) on version %d err:%v", expr, version, err)
+			}
+			assert.Equalf(t, want.ExternalDocIDs, got.ExternalDocIDs, "DoQuery(%s) on version %d", expr, version)
+		}
+	}
+
+	// a version 3 text field misses the term frequencies BM25 needs
+	if err := seg.SetAnalyzer("bio", "standard"); err != nil {
+		t.Fatalf("SetAnalyzer() err:%v", err)
+	}
+	if _, err := index.LoadSegment(downgrade(seg, 3, 3)); err == nil {
+		t.Errorf("LoadSegment() of a version 3 segment with a text field expected an error")
+	}
+	if _, err := index.LoadSegment(downgrade(seg, 6, 0)); err == nil {
+		t.Errorf("LoadSegment() of a future version expected an error")
+	}
+}
+
+func TestSegment_UpdateDelete(t *testing.T) {
+	type person struct {
+		id, name, bio string
+		tags          []string
+		age           int64
+		vip           bool
+	}
+	doc := func(p person) index.Document {
+		return index.NewDocument(p.id, map[string]value.Value{
+			"name": value.NewStringValue(p.name),
+			"bio":  value.NewStringValue(p.bio),
+			"tags": value.NewStringsValue(p.tags),
+			"age":  value.NewIntValue(p.age),
+			"vip":  value.NewBoolValue(p.vip),
+		}, time.Now())
+	}
+	build := func(people ...person) *index.Segment {
+		seg := index.NewSegment(10)
+		if err := seg.SetAnalyzer("bio", "standard"); err != nil {
+			t.Fatalf("SetAnalyzer() err:%v", err)
+		}
+		for _, p := range people {
+			if err := seg.IndexDocuments(context.Background(), []index.Document{doc(p)}); err != nil {
+				t.Fatalf("IndexDocuments() err:%v", err)
+			}
+		}
+		return seg
+	}
+
+	p1 := person{"1", "eric", "likes coffee and tea", []string{"a", "b"}, 20, true}
+	p2 := person{"2", "kevin", "likes tea", []string{"b"}, 30, false}
+	p3 := person{"3", "angela", "coffee coffee", []string{"c"}, 40, true}
+	p2v2 := person{"2", "kevin", "drinks water", []string{"d", "d"}, 31, true}
+	want := build(p1, p2v2)
+
+	// the postings of the docs are recorded as they're indexed, those of a loaded segment are not
+	updated := build(p1, p2, p3)
+	var buf bytes.Buffer
+	if _, err := updated.WriteTo(&buf); err != nil {
+		t.Fatalf("Segment.WriteTo() err:%v", err)
+	}
+	loaded, err := index.LoadSegment(buf.Bytes())
+	if err != nil {
+		t.Fatalf("LoadSegment() err:%v", err)
+	}
+
+	for name, seg := range map[string]*index.Segment{"recorded": updated, "loaded": loaded} {
+		if err := seg.IndexDocuments(context.Background(), []index.Document{doc(p2v2)}); err != nil {
+			t.Fatalf("%s: IndexDocuments() err:%v", name, err)
+		}
+		if err := seg.DeleteDocuments(context.Background(), []string{"3"}); err != nil {
+			t.Fatalf("%s: DeleteDocuments() err:%v", name, err)
+		}
+
+		for _, expr := range []string{
+			`name == "kevin"`, `name == "angela"`, `like(name, ".*")`, `bio == "tea"`, `bio == "coffee"`,
+			`bio == "water"`, `tags == "b"`, `tags == "d"`, `like(tags, ".*")`, `age >= 30`, `age == 40`,
+			`vip`, `!vip`, `prefix(bio, "")`,
+		} {
+			wantRes, err := index.DoQuery(expr, want)
+			if err != nil {
+				t.Fatalf("DoQuery(%s) err:%v", expr, err)
+			}
+			got, err := index.DoQuery(expr, seg)
+			if err != nil {
+				t.Fatalf("%s: DoQuery(%s) err:%v", name, expr, err)
+			}
+			assert.Equalf(t, wantRes.ExternalDocIDs, got.ExternalDocIDs, "%s: DoQuery(%s)", name, expr)
+		}
+	}
+}
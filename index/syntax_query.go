@@ -7,19 +7,81 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"go/types"
+	"math"
+	goregexp "regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/RoaringBitmap/roaring"
 	"github.com/araddon/qlbridge/value"
 )
 
 func init() {
-	funcNameMap = map[string]qFunc{
+	funcNameMap = map[string]evalFunc{
 		"in_array": inArray,
 		"like":     like,
+		"between":  between,
+		"match":    match,
+		"prefix":   prefix,
+		"wildcard": wildcard,
+		"fuzzy":    fuzzy,
+		"ilike":    ilike,
+		"iequals":  iequals,
 	}
 }
 
+// evalContext carries the state of a query evaluation on a segment
+type evalContext struct {
+	ctx context.Context // carries the automata of a prepared query to the segment queries, see withAutomata
+	seg *Segment
+	now time.Time // value of `now()`, the same for the whole query, whatever the segment
+
+	// all the segments the query runs on and the deleted docs, for the statistics of scoring queries
+	segments  []*Segment
+	deleted   *roaring.Bitmap
+	textStats map[string]*textStats // field and query text --> statistics, collected once for all the segments
+
+	params  map[token.Pos]value.Value // values of the placeholders of the query, see Params
+	explain map[ast.Expr]*ExplainNode // nodes of the plan when the query is explained, see Index.Explain
+}
+
+func newEvalContext(segments []*Segment, deleted *roaring.Bitmap) *evalContext {
+	return &evalContext{
+		ctx:       context.Background(),
+		now:       time.Now(),
+		segments:  segments,
+		deleted:   deleted,
+		textStats: make(map[string]*textStats),
+	}
+}
+
+// onSegment returns a copy of the context to evaluate the same query on another segment
+func (ec *evalContext) onSegment(seg *Segment) *evalContext {
+	c := *ec
+	c.seg = seg
+	return &c
+}
+
+// fieldTextStats returns the statistics of the text field for the tokens of the query text
+func (ec *evalContext) fieldTextStats(field, text string) *textStats {
+	key := field + "\x00" + text
+	if stats, ok := ec.textStats[key]; ok {
+		return stats
+	}
+
+	var tokens []Token
+	if fieldID, ok := ec.seg.fieldToFieldId[field]; ok {
+		if fa, ok := ec.seg.analyzers[fieldID]; ok {
+			tokens = fa.analyzer.Analyze(text)
+		}
+	}
+	stats := collectTextStats(ec.segments, ec.deleted, field, tokens)
+	ec.textStats[key] = stats
+	return stats
+}
+
 // DoQuery parse query to ast and do the query
 func DoQuery(query string, seg *Segment) (*SearchResults, error) {
 	qryExpr, err := parser.ParseExpr(query)
@@ -27,7 +89,7 @@ func DoQuery(query string, seg *Segment) (*SearchResults, error) {
 		return nil, err
 	}
 
-	res, err := qeval(qryExpr, seg)
+	res, err := qeval(qryExpr, newEvalContext([]*Segment{seg}, roaring.New()).onSegment(seg))
 	if err != nil {
 		return nil, err
 	}
@@ -35,31 +97,38 @@ func DoQuery(query string, seg *Segment) (*SearchResults, error) {
 	return res.BuildExternalIDs(seg)
 }
 
-func qeval(expr ast.Expr, seg *Segment) (*SearchResults, error) {
+func qeval(expr ast.Expr, ec *evalContext) (*SearchResults, error) {
+	if node, ok := ec.explain[expr]; ok {
+		return node.eval(expr, ec)
+	}
+	return evalExpr(expr, ec)
+}
+
+// evalExpr evaluates the expression on the segment of the context, the sub expressions are evaluated by qeval
+func evalExpr(expr ast.Expr, ec *evalContext) (*SearchResults, error) {
+	seg := ec.seg
+
 	switch expr := expr.(type) {
 	case *ast.BinaryExpr: // binary expression
 		op := expr.Op
 		switch op {
-		case token.LAND, token.LOR: // && ||
-			xres, xerr := qeval(expr.X, seg)
-			yres, yerr := qeval(expr.Y, seg)
+		case token.LAND: // the conditions of a chain of && are planned together
+			return evalAnd(expr, ec)
+		case token.LOR: // ||
+			xres, xerr := qeval(expr.X, ec)
+			yres, yerr := qeval(expr.Y, ec)
 			if xerr != nil || yerr != nil {
-				return nil, fmt.Errorf("eval expression: %+v failed. xerr:%v, yerr:%v", expr, xerr, yerr)
+				return nil, fmt.Errorf("eval expression: %s failed. xerr:%v, yerr:%v", types.ExprString(expr), xerr, yerr)
 			}
 
-			switch op {
-			case token.LAND:
-				return xres.And(yres), nil
-			case token.LOR:
-				return xres.Or(yres), nil
-			}
+			return xres.Or(yres), nil
 		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GEQ, token.GTR: // == != using tag index
 			ident, err := parseIdent(expr.X)
 			if err != nil {
 				return nil, err
 			}
 
-			lit, err := parseBasicLit(expr.Y)
+			lit, err := parseBasicLit(expr.Y, ec)
 			if err != nil {
 				return nil, fmt.Errorf("`%s` expression: %s", op, err)
 			}
@@ -71,7 +140,7 @@ func qeval(expr ast.Expr, seg *Segment) (*SearchResults, error) {
 					return nil, err
 				}
 
-				qres, err := NewQueryBuilder(context.TODO(), seg).And(query).Run(true)
+				qres, err := NewQueryBuilder(ec.ctx, seg).And(query).Run(true)
 				if err != nil {
 					return qres, err
 				}
@@ -87,32 +156,44 @@ func qeval(expr ast.Expr, seg *Segment) (*SearchResults, error) {
 					return nil, err
 				}
 
-				return NewQueryBuilder(context.TODO(), seg).And(query).Run(true)
+				return NewQueryBuilder(ec.ctx, seg).And(query).Run(true)
 			}
 
 		default:
 			return nil, fmt.Errorf("operator:%s not implemented", op)
 		}
 
+	case *ast.Ident, *ast.SelectorExpr: // bare bool field as a predicate, the same as `field == true`
+		ident, err := parseIdent(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		query, err := NewQuery(TypeTermQuery, ident, value.NewBoolValue(true))
+		if err != nil {
+			return nil, err
+		}
+
+		return NewQueryBuilder(ec.ctx, seg).And(query).Run(true)
 	case *ast.CallExpr: // function call
-		return calculateForFunc(expr.Fun.(*ast.Ident).Name, expr.Args, seg)
+		return calculateForFunc(expr.Fun.(*ast.Ident).Name, expr.Args, ec)
 	case *ast.ParenExpr:
-		return qeval(expr.X, seg)
+		return qeval(expr.X, ec)
 	case *ast.UnaryExpr:
-		xres, err := qeval(expr.X, seg)
+		xres, err := qeval(expr.X, ec)
 		if xres == nil || err != nil {
-			return nil, fmt.Errorf("%+v is nil", expr.X)
+			return nil, fmt.Errorf("%s is nil", types.ExprString(expr.X))
 		}
 		op := expr.Op
 		switch op {
 		case token.NOT:
 			return xres.Not(seg), nil
 		}
-		return nil, fmt.Errorf("%x type is not support", expr)
+		return nil, fmt.Errorf("%s type is not support", types.ExprString(expr))
 
 	default:
 	}
-	return nil, fmt.Errorf("%x type is not support", expr)
+	return nil, fmt.Errorf("%s type is not support", types.ExprString(expr))
 }
 
 func parseIdent(expr ast.Expr) (string, error) {
@@ -131,45 +212,304 @@ func parseIdent(expr ast.Expr) (string, error) {
 		ident.WriteString(expr.Sel.Name)
 		return ident.String(), nil
 	default:
-		return "", fmt.Errorf("expr must be a *ast.Ident, got %x", expr)
+		return "", fmt.Errorf("expr must be a *ast.Ident, got %s", types.ExprString(expr))
 	}
 }
 
-func parseBasicLit(expr ast.Expr) (value.Value, error) {
+func parseBasicLit(expr ast.Expr, ec *evalContext) (value.Value, error) {
 	switch expr := expr.(type) {
 	case *ast.BasicLit:
 		switch expr.Kind {
-		case token.INT:
-			num, err := strconv.ParseInt(expr.Value, 10, 64)
+		case token.INT: // decimal, hex, octal and binary forms, with underscores, as in go: 0x1F, 0o17, 0b101, 1_000
+			num, err := strconv.ParseInt(expr.Value, 0, 64)
+			if errors.Is(err, strconv.ErrRange) { // above math.MaxInt64, only unsigned fields hold such values
+				unum, uerr := strconv.ParseUint(expr.Value, 0, 64)
+				if uerr == nil {
+					return NewUintValue(unum), nil
+				}
+			}
 			if err != nil {
 				return value.NilValueVal, err
 			}
 			return value.NewIntValue(num), nil //
+		case token.FLOAT:
+			num, err := strconv.ParseFloat(expr.Value, 64)
+			if err != nil {
+				return value.NilValueVal, err
+			}
+			return value.NewNumberValue(num), nil
 		case token.STRING:
 			str, err := strconv.Unquote(expr.Value)
 			return value.NewStringValue(str), err
 		default:
 			return value.NilValueVal, fmt.Errorf("unsupport type:%s", expr.Kind)
 		}
+	case *ast.CallExpr: // literal function, such as time("2024-01-01T00:00:00Z")
+		return calculateForLitFunc(expr, ec)
+	case *ast.BinaryExpr: // arithmetic on literals, such as now() - duration("24h") or 60*60*24
+		return foldBinaryLit(expr, ec)
+	case *ast.UnaryExpr: // signed literal, such as -5
+		return foldUnaryLit(expr, ec)
+	case *ast.ParenExpr:
+		return parseBasicLit(expr.X, ec)
+	case *ast.Ident: // placeholder or bool literal
+		if param, ok := ec.params[expr.Pos()]; ok {
+			if _, ok := param.(value.SliceValue); ok {
+				return value.NilValueVal, fmt.Errorf("a slice is only accepted by in_array, got %s", param.ToString())
+			}
+			return param, nil
+		}
+		switch expr.Name {
+		case "true":
+			return value.NewBoolValue(true), nil
+		case "false":
+			return value.NewBoolValue(false), nil
+		default:
+			return value.NilValueVal, fmt.Errorf("unsupport literal:%s", expr.Name)
+		}
+	default:
+		return value.NilValueVal, fmt.Errorf("expr must be a *ast.BasicLit, got %s", types.ExprString(expr))
+	}
+}
+
+// timeLayouts are the layouts accepted by the `time()` literal function, a time without zone is UTC
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// calculateForLitFunc 计算字面量函数：time("2024-01-01T00:00:00Z")、now()、duration("24h")
+func calculateForLitFunc(expr *ast.CallExpr, ec *evalContext) (value.Value, error) {
+	fn, ok := expr.Fun.(*ast.Ident)
+	if !ok {
+		return value.NilValueVal, fmt.Errorf("expr must be a literal function call, got %s", types.ExprString(expr))
+	}
+
+	switch fn.Name {
+	case "now":
+		if len(expr.Args) != 0 {
+			return value.NilValueVal, fmt.Errorf("func now: expected no argument")
+		}
+		return value.NewTimeValue(ec.now), nil
+	case "time", "duration":
+		if len(expr.Args) != 1 {
+			return value.NilValueVal, fmt.Errorf(`func %s: expected 1 string argument`, fn.Name)
+		}
+		arg, err := parseBasicLit(expr.Args[0], ec)
+		if err != nil {
+			return value.NilValueVal, err
+		}
+		str, ok := arg.(value.StringValue)
+		if !ok {
+			return value.NilValueVal, fmt.Errorf(`func %s: expected 1 string argument, got %s`, fn.Name, arg.Type())
+		}
+
+		if fn.Name == "duration" {
+			d, err := time.ParseDuration(str.Val())
+			if err != nil {
+				return value.NilValueVal, fmt.Errorf("func duration: %s", err)
+			}
+			return NewDurationValue(d), nil
+		}
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, str.Val()); err == nil {
+				return value.NewTimeValue(t), nil
+			}
+		}
+		return value.NilValueVal, fmt.Errorf("func time: cannot parse %q, expected RFC3339 or 2006-01-02", str.Val())
+	default:
+		return value.NilValueVal, fmt.Errorf("func:%s is not a literal function", fn.Name)
+	}
+}
+
+// foldUnaryLit computes the sign of a literal: -5, -1.5, -duration("1h"), +5
+func foldUnaryLit(expr *ast.UnaryExpr, ec *evalContext) (value.Value, error) {
+	if expr.Op != token.SUB && expr.Op != token.ADD {
+		return value.NilValueVal, fmt.Errorf("`%s` is not supported on literals", expr.Op)
+	}
+	x, err := parseBasicLit(expr.X, ec)
+	if err != nil {
+		return value.NilValueVal, err
+	}
+
+	if expr.Op == token.ADD {
+		switch x.(type) {
+		case value.IntValue, value.NumberValue, UintValue, DurationValue:
+			return x, nil
+		}
+	}
+
+	switch v := x.(type) {
+	case value.IntValue:
+		if v.Val() == math.MinInt64 {
+			return value.NilValueVal, fmt.Errorf("-(%d) overflows int64", v.Val())
+		}
+		return value.NewIntValue(-v.Val()), nil
+	case UintValue: // -9223372036854775808 is parsed as the negation of an unsigned literal
+		if v.Val() > 1<<63 {
+			return value.NilValueVal, fmt.Errorf("-%d overflows int64", v.Val())
+		}
+		return value.NewIntValue(int64(-v.Val())), nil
+	case value.NumberValue:
+		return value.NewNumberValue(-v.Val()), nil
+	case DurationValue:
+		return NewDurationValue(-v.Val()), nil
+	}
+	return value.NilValueVal, fmt.Errorf("`%s` operand must be a number or a duration, got %s", expr.Op, x.Type())
+}
+
+// foldBinaryLit computes arithmetic on literals: constant folding of numbers, such as `1<<10` or `60*60*24`, and
+// time ± duration and duration ± duration
+func foldBinaryLit(expr *ast.BinaryExpr, ec *evalContext) (value.Value, error) {
+	x, err := parseBasicLit(expr.X, ec)
+	if err != nil {
+		return value.NilValueVal, err
+	}
+	y, err := parseBasicLit(expr.Y, ec)
+	if err != nil {
+		return value.NilValueVal, err
+	}
+
+	switch x.(type) {
+	case value.TimeValue, DurationValue:
+		return foldTimeLit(expr.Op, x, y)
+	case value.IntValue, value.NumberValue:
+		return foldNumberLit(expr.Op, x, y)
 	default:
-		return value.NilValueVal, fmt.Errorf("expr must be a *ast.BasicLit, got %x", expr)
+		return value.NilValueVal, fmt.Errorf("`%s` left operand must be a number, a time or a duration, got %s", expr.Op, x.Type())
+	}
+}
+
+// foldTimeLit computes time ± duration and duration ± duration
+func foldTimeLit(op token.Token, x, y value.Value) (value.Value, error) {
+	if op != token.ADD && op != token.SUB {
+		return value.NilValueVal, fmt.Errorf("`%s` is not supported on times and durations", op)
+	}
+	d, ok := y.(DurationValue)
+	if !ok {
+		return value.NilValueVal, fmt.Errorf("`%s` right operand must be a duration, got %s", op, y.Type())
+	}
+	delta := d.Val()
+	if op == token.SUB {
+		delta = -delta
+	}
+
+	if t, ok := x.(value.TimeValue); ok {
+		return value.NewTimeValue(t.Val().Add(delta)), nil
+	}
+	return NewDurationValue(x.(DurationValue).Val() + delta), nil
+}
+
+// foldNumberLit computes arithmetic on numbers as go constants do: integers stay integers, and an integer with a
+// float is a float. Integer overflows and divisions by zero are errors rather than wrapped around.
+func foldNumberLit(op token.Token, x, y value.Value) (value.Value, error) {
+	xi, xInt := x.(value.IntValue)
+	yi, yInt := y.(value.IntValue)
+	if xInt && yInt {
+		n, err := foldIntLit(op, xi.Val(), yi.Val())
+		if err != nil {
+			return value.NilValueVal, err
+		}
+		return value.NewIntValue(n), nil
+	}
+
+	toFloat := func(v value.Value) (float64, bool) {
+		switch v := v.(type) {
+		case value.IntValue:
+			return float64(v.Val()), true
+		case value.NumberValue:
+			return v.Val(), true
+		}
+		return 0, false
+	}
+	a, _ := toFloat(x)
+	b, ok := toFloat(y)
+	if !ok {
+		return value.NilValueVal, fmt.Errorf("`%s` right operand must be a number, got %s", op, y.Type())
+	}
+	switch op {
+	case token.ADD:
+		return value.NewNumberValue(a + b), nil
+	case token.SUB:
+		return value.NewNumberValue(a - b), nil
+	case token.MUL:
+		return value.NewNumberValue(a * b), nil
+	case token.QUO:
+		if b == 0 {
+			return value.NilValueVal, errors.New("division by zero")
+		}
+		return value.NewNumberValue(a / b), nil
+	default:
+		return value.NilValueVal, fmt.Errorf("`%s` is only supported on integers", op)
+	}
+}
+
+func foldIntLit(op token.Token, a, b int64) (int64, error) {
+	overflow := fmt.Errorf("%d %s %d overflows int64", a, op, b)
+	switch op {
+	case token.ADD:
+		if r := a + b; (a > 0 && b > 0 && r < 0) || (a < 0 && b < 0 && r >= 0) {
+			return 0, overflow
+		}
+		return a + b, nil
+	case token.SUB:
+		if r := a - b; (a >= 0 && b < 0 && r < 0) || (a < 0 && b > 0 && r >= 0) {
+			return 0, overflow
+		}
+		return a - b, nil
+	case token.MUL:
+		if r := a * b; a != 0 && (r/a != b || (a == -1 && b == math.MinInt64)) {
+			return 0, overflow
+		}
+		return a * b, nil
+	case token.QUO, token.REM:
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		if a == math.MinInt64 && b == -1 {
+			return 0, overflow
+		}
+		if op == token.QUO {
+			return a / b, nil
+		}
+		return a % b, nil
+	case token.SHL:
+		if b < 0 || b >= 64 || (a<<b)>>b != a {
+			return 0, overflow
+		}
+		return a << b, nil
+	case token.SHR:
+		if b < 0 {
+			return 0, fmt.Errorf("negative shift count %d", b)
+		}
+		return a >> min(b, 63), nil
+	case token.AND:
+		return a & b, nil
+	case token.OR:
+		return a | b, nil
+	case token.XOR:
+		return a ^ b, nil
+	case token.AND_NOT:
+		return a &^ b, nil
+	default:
+		return 0, fmt.Errorf("`%s` is not supported on literals", op)
 	}
 }
 
 // calculateForFunc 计算函数表达式
-func calculateForFunc(funcName string, args []ast.Expr, seg *Segment) (*SearchResults, error) {
+func calculateForFunc(funcName string, args []ast.Expr, ec *evalContext) (*SearchResults, error) {
 	// 根据funcName分发逻辑
 	handler, ok := funcNameMap[funcName]
 	if !ok {
 		return nil, fmt.Errorf("func:%s not support", funcName)
 	}
-	return handler(args, seg)
+	return handler(args, ec)
 }
 
 // 注册可执行函数
-var funcNameMap = map[string]qFunc{}
+var funcNameMap = map[string]evalFunc{}
 
-type qFunc func(args []ast.Expr, seg *Segment) (*SearchResults, error)
+type (
+	qFunc    func(args []ast.Expr, seg *Segment) (*SearchResults, error)
+	evalFunc func(args []ast.Expr, ec *evalContext) (*SearchResults, error) // the built-in functions get the whole evaluation context
+)
 
 // RegisterFunc 用户可注册自定义条件判断函数。对应函数返回值，如果输入参数导致程序发生错误，则返回 error，如果能正常判断则返回 true/false
 func RegisterFunc(name string, fun qFunc) error {
@@ -177,7 +517,9 @@ func RegisterFunc(name string, fun qFunc) error {
 		return fmt.Errorf("func %s() already registered", name)
 	}
 
-	funcNameMap[name] = fun
+	funcNameMap[name] = func(args []ast.Expr, ec *evalContext) (*SearchResults, error) {
+		return fun(args, ec.seg)
+	}
 	return nil
 }
 
@@ -185,7 +527,7 @@ func RegisterFunc(name string, fun qFunc) error {
 //
 //	函数调用语法：in_array(location, []string{"南山", "福田"})
 //	 - 其中第一个参数为变量名，第二个参数为 golang slice, 支持 []int32/64/uint...{} \ []string{}
-func inArray(args []ast.Expr, seg *Segment) (*SearchResults, error) {
+func inArray(args []ast.Expr, ec *evalContext) (*SearchResults, error) {
 	if len(args) != 2 {
 		return nil, fmt.Errorf(`func in_array: expected 2 arguments, example: in_array(name, []string{"chirl", "minute"})`)
 	}
@@ -194,18 +536,33 @@ func inArray(args []ast.Expr, seg *Segment) (*SearchResults, error) {
 	if err != nil {
 		return nil, err
 	}
-	vRange, ok := args[1].(*ast.CompositeLit)
-	if !ok {
+	var elts []value.Value
+	switch vRange := args[1].(type) {
+	case *ast.CompositeLit:
+		for _, p := range vRange.Elts {
+			elt, err := parseBasicLit(p, ec)
+			if err != nil {
+				return nil, err
+			}
+			elts = append(elts, elt)
+		}
+	case *ast.Ident: // placeholder bound to a slice, such as in_array(City, :cities)
+		param, ok := ec.params[vRange.Pos()]
+		if !ok {
+			return nil, errors.New("func in_array 2ed params is not a composite lit")
+		}
+		slice, ok := param.(value.SliceValue)
+		if !ok {
+			return nil, fmt.Errorf("func in_array 2ed params is not a slice, got %s", param.Type())
+		}
+		elts = slice.Val()
+	default:
 		return nil, errors.New("func in_array 2ed params is not a composite lit")
 	}
 
 	// 规则表达式中数组里的元素
-	queries := make([]Query, 0, len(vRange.Elts))
-	for _, p := range vRange.Elts {
-		elt, err := parseBasicLit(p)
-		if err != nil {
-			return nil, err
-		}
+	queries := make([]Query, 0, len(elts))
+	for _, elt := range elts {
 
 		q, err := NewQuery(TypeTermQuery, ident, elt)
 		if err != nil {
@@ -214,10 +571,10 @@ func inArray(args []ast.Expr, seg *Segment) (*SearchResults, error) {
 		queries = append(queries, q)
 	}
 
-	return NewQueryBuilder(context.TODO(), seg).Or(queries...).Run(true)
+	return NewQueryBuilder(ec.ctx, ec.seg).Or(queries...).Run(true)
 }
 
-func like(args []ast.Expr, seg *Segment) (*SearchResults, error) {
+func like(args []ast.Expr, ec *evalContext) (*SearchResults, error) {
 	if len(args) != 2 {
 		return nil, fmt.Errorf(`func like: expected 2 arguments, example: in_array(name, []string{"chirl", "minute"})`)
 	}
@@ -225,7 +582,7 @@ func like(args []ast.Expr, seg *Segment) (*SearchResults, error) {
 	if err != nil {
 		return nil, err
 	}
-	elt, err := parseBasicLit(args[1])
+	elt, err := parseBasicLit(args[1], ec)
 	if err != nil {
 		return nil, err
 	}
@@ -234,5 +591,164 @@ func like(args []ast.Expr, seg *Segment) (*SearchResults, error) {
 		return nil, err
 	}
 
-	return NewQueryBuilder(context.TODO(), seg).Or(query).Run(true)
+	return NewQueryBuilder(ec.ctx, ec.seg).Or(query).Run(true)
+}
+
+// ilike 忽略大小写的正则匹配
+//
+//	函数调用语法：ilike(Name.First, "VIC.*")
+func ilike(args []ast.Expr, ec *evalContext) (*SearchResults, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf(`func ilike: expected 2 arguments, example: ilike(Name.First, "VIC.*")`)
+	}
+	return caseInsensitiveRegEx(args, ec, func(pattern string) string { return pattern })
+}
+
+// iequals 忽略大小写的等值匹配
+//
+//	函数调用语法：iequals(Name.First, "Vicky")
+func iequals(args []ast.Expr, ec *evalContext) (*SearchResults, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf(`func iequals: expected 2 arguments, example: iequals(Name.First, "Vicky")`)
+	}
+	return caseInsensitiveRegEx(args, ec, goregexp.QuoteMeta)
+}
+
+// caseInsensitiveRegEx matches the terms of the field with the regular expression built from the string value
+func caseInsensitiveRegEx(args []ast.Expr, ec *evalContext, toRegEx func(s string) string) (*SearchResults, error) {
+	ident, err := parseIdent(args[0])
+	if err != nil {
+		return nil, err
+	}
+	elt, err := parseBasicLit(args[1], ec)
+	if err != nil {
+		return nil, err
+	}
+	if elt.Type() == value.StringType {
+		elt = value.NewStringValue("(?i)" + toRegEx(elt.Value().(string)))
+	}
+	query, err := NewQuery(TypeRegExQuery, ident, elt)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewQueryBuilder(ec.ctx, ec.seg).Or(query).Run(true)
+}
+
+// match 文本字段分词匹配，查询文本使用字段的分析器分词，匹配包含全部词元的文档
+//
+//	函数调用语法：match(Desc, "深圳南山")
+func match(args []ast.Expr, ec *evalContext) (*SearchResults, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf(`func match: expected 2 arguments, example: match(Desc, "深圳南山")`)
+	}
+	ident, err := parseIdent(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := parseBasicLit(args[1], ec)
+	if err != nil {
+		return nil, err
+	}
+	query, err := NewQuery(TypeMatchQuery, ident, text)
+	if err != nil {
+		return nil, err
+	}
+	query.(*MatchQuery).stats = ec.fieldTextStats(ident, text.ToString())
+
+	return NewQueryBuilder(ec.ctx, ec.seg).And(query).Run(true)
+}
+
+// prefix 匹配以指定前缀开头的字符串
+//
+//	函数调用语法：prefix(Name.First, "vic")
+func prefix(args []ast.Expr, ec *evalContext) (*SearchResults, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf(`func prefix: expected 2 arguments, example: prefix(Name.First, "vic")`)
+	}
+	return termDicQuery(TypePrefixQuery, args, ec)
+}
+
+// wildcard 通配符匹配，`?` 匹配任意单个字符，`*` 匹配任意个字符
+//
+//	函数调用语法：wildcard(Name.First, "v?ck*")
+func wildcard(args []ast.Expr, ec *evalContext) (*SearchResults, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf(`func wildcard: expected 2 arguments, example: wildcard(Name.First, "v?ck*")`)
+	}
+	return termDicQuery(TypeWildcardQuery, args, ec)
+}
+
+// fuzzy 模糊匹配与指定字符串的编辑距离不超过 fuzziness 的字符串，fuzziness 省略时为 1，最大为 MaxFuzziness
+//
+//	函数调用语法：fuzzy(Name.First, "vikcy", 2)
+func fuzzy(args []ast.Expr, ec *evalContext) (*SearchResults, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf(`func fuzzy: expected 2 or 3 arguments, example: fuzzy(Name.First, "vikcy", 2)`)
+	}
+	if len(args) == 2 {
+		return termDicQuery(TypeFuzzyQuery, args, ec)
+	}
+
+	dist, err := parseBasicLit(args[2], ec)
+	if err != nil {
+		return nil, err
+	}
+	fuzziness, ok := dist.(value.IntValue)
+	if !ok || fuzziness.Val() < 0 || fuzziness.Val() > MaxFuzziness {
+		return nil, fmt.Errorf("func fuzzy: fuzziness must be an integer between 0 and %d, got %s", MaxFuzziness, dist.ToString())
+	}
+	return termDicQuery(TypeFuzzyQuery, args[:2], ec, func(q Query) {
+		q.(*FuzzyQuery).Fuzziness = uint8(fuzziness.Val())
+	})
+}
+
+// termDicQuery runs a query of the term dictionary of the field, args are the field and the string value
+func termDicQuery(qtype QType, args []ast.Expr, ec *evalContext, setters ...func(q Query)) (*SearchResults, error) {
+	ident, err := parseIdent(args[0])
+	if err != nil {
+		return nil, err
+	}
+	elt, err := parseBasicLit(args[1], ec)
+	if err != nil {
+		return nil, err
+	}
+	query, err := NewQuery(qtype, ident, elt)
+	if err != nil {
+		return nil, err
+	}
+	for _, set := range setters {
+		set(query)
+	}
+
+	return NewQueryBuilder(ec.ctx, ec.seg).Or(query).Run(true)
+}
+
+// between 判断变量是否在闭区间 [lo, hi] 内，与 `field >= lo && field <= hi` 等价
+//
+//	函数调用语法：between(CreatedAt, time("2024-01-01T00:00:00Z"), now())
+func between(args []ast.Expr, ec *evalContext) (*SearchResults, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf(`func between: expected 3 arguments, example: between(Age, 18, 30)`)
+	}
+	ident, err := parseIdent(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	queries := make([]Query, 0, 2)
+	for i, qtype := range []QType{TypeRangeGEQuery, TypeRangeLEQuery} {
+		bound, err := parseBasicLit(args[i+1], ec)
+		if err != nil {
+			return nil, err
+		}
+		q, err := NewQuery(qtype, ident, bound)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+
+	return NewQueryBuilder(ec.ctx, ec.seg).And(queries...).Run(true)
 }
@@ -0,0 +1,201 @@
+package index
+
+import (
+	"fmt"
+)
+
+// TypedIndex 是 Index 的泛型封装：文档、排序函数、过滤函数以及查询结果都是 T 类型，类型错误在编译期即可发现，
+// 而不是在运行时类型断言时 panic。
+//
+//	idx, err := index.NewTypedIndex(keys, []Cfg{d1, d2})
+//	cfgs, err := idx.Query(`Age >= 22`, index.WithTypedLess(func(a, b Cfg) bool { return a.ID < b.ID }))
+type TypedIndex[T any] struct {
+	idx *Index
+}
+
+type (
+	// TypedOption is a query option of a TypedIndex[T], see WithTypedLess, WithTypedFilter and TypedOptions
+	TypedOption[T any] func(o *Options)
+
+	TypedPreprocess[T any] func(in T) (got T)
+)
+
+// NewTypedIndex 使用 T 类型的文档列表构建索引
+func NewTypedIndex[T any](keys []string, docs []T, preprocFn ...TypedPreprocess[T]) (*TypedIndex[T], error) {
+	anyDocs := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		anyDocs = append(anyDocs, doc)
+	}
+
+	fns := make([]Preprocess, 0, len(preprocFn))
+	for _, fn := range preprocFn {
+		if fn == nil {
+			continue
+		}
+		fn := fn
+		fns = append(fns, func(in interface{}) interface{} { return fn(in.(T)) })
+	}
+
+	idx, err := NewIndex(keys, anyDocs, fns...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedIndex[T]{idx: idx}, nil
+}
+
+// Untyped returns the underlying index, its docs are all of type T
+func (t *TypedIndex[T]) Untyped() *Index {
+	return t.idx
+}
+
+// SetMergePolicy 设置缓冲段封存以及段合并的策略
+func (t *TypedIndex[T]) SetMergePolicy(policy MergePolicy) {
+	t.idx.SetMergePolicy(policy)
+}
+
+// Upsert 新增或更新单个文档
+func (t *TypedIndex[T]) Upsert(key string, doc T) error {
+	return t.idx.Upsert(key, doc)
+}
+
+// Delete 删除文档，不存在的文档会被忽略
+func (t *TypedIndex[T]) Delete(keys ...string) error {
+	return t.idx.Delete(keys...)
+}
+
+// Flush seals the write buffer, see Index.Flush
+func (t *TypedIndex[T]) Flush() {
+	t.idx.Flush()
+}
+
+// ForceMerge merges all the segments into one, see Index.ForceMerge
+func (t *TypedIndex[T]) ForceMerge() error {
+	return t.idx.ForceMerge()
+}
+
+// Query 查询并返回 T 类型的文档
+func (t *TypedIndex[T]) Query(query string, opts ...TypedOption[T]) ([]T, error) {
+	docs, err := t.idx.Query(query, untypedOptions(opts)...)
+	if docs == nil {
+		return nil, err
+	}
+	return typedDocs[T](docs, err)
+}
+
+// QueryWithParams 参数化查询并返回 T 类型的文档，见 Index.QueryWithParams
+func (t *TypedIndex[T]) QueryWithParams(query string, params Params, opts ...TypedOption[T]) ([]T, error) {
+	docs, err := t.idx.QueryWithParams(query, params, untypedOptions(opts)...)
+	if docs == nil {
+		return nil, err
+	}
+	return typedDocs[T](docs, err)
+}
+
+// TypedPreparedQuery is a PreparedQuery of a TypedIndex[T], see TypedIndex.Prepare
+type TypedPreparedQuery[T any] struct {
+	pq *PreparedQuery
+}
+
+// Prepare 解析并检查查询，返回可以多次执行的查询，见 Index.Prepare
+func (t *TypedIndex[T]) Prepare(query string) (*TypedPreparedQuery[T], error) {
+	pq, err := t.idx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedPreparedQuery[T]{pq: pq}, nil
+}
+
+// Query 执行查询并返回 T 类型的文档
+func (p *TypedPreparedQuery[T]) Query(opts ...TypedOption[T]) ([]T, error) {
+	return p.QueryWithParams(nil, opts...)
+}
+
+// QueryWithParams 使用参数执行查询并返回 T 类型的文档
+func (p *TypedPreparedQuery[T]) QueryWithParams(params Params, opts ...TypedOption[T]) ([]T, error) {
+	docs, err := p.pq.QueryWithParams(params, untypedOptions(opts)...)
+	if docs == nil {
+		return nil, err
+	}
+	return typedDocs[T](docs, err)
+}
+
+// TypedHit is a doc of type T matched by a query along with its relevance score, see Hit
+type TypedHit[T any] struct {
+	Key   string
+	Score float64
+	Doc   T
+}
+
+// Search 查询并返回 T 类型的文档及其相关性得分，见 Index.Search
+func (t *TypedIndex[T]) Search(query string, opts ...TypedOption[T]) ([]TypedHit[T], error) {
+	hits, err := t.idx.Search(query, untypedOptions(opts)...)
+	if hits == nil {
+		return nil, err
+	}
+
+	res := make([]TypedHit[T], 0, len(hits))
+	for _, hit := range hits {
+		doc, ok := hit.Doc.(T)
+		if !ok {
+			return nil, fmt.Errorf("doc of type %T is not a %T, check the preprocess functions", hit.Doc, doc)
+		}
+		res = append(res, TypedHit[T]{Key: hit.Key, Score: hit.Score, Doc: doc})
+	}
+	return res, err
+}
+
+// GetDocs 按照 key 获取 T 类型的文档，不存在的 key 会被忽略
+func (t *TypedIndex[T]) GetDocs(keys []string, opts ...TypedOption[T]) ([]T, error) {
+	docs, err := t.idx.GetDocs(keys, untypedOptions(opts)...)
+	if docs == nil {
+		return nil, err
+	}
+	return typedDocs[T](docs, err)
+}
+
+// typedDocs converts the docs returned by the untyped index, `err` is passed through as paging returns docs along
+// with ErrEOF
+func typedDocs[T any](docs []interface{}, err error) ([]T, error) {
+	res := make([]T, 0, len(docs))
+	for _, doc := range docs {
+		tdoc, ok := doc.(T)
+		if !ok {
+			return nil, fmt.Errorf("doc of type %T is not a %T, check the preprocess functions", doc, tdoc)
+		}
+		res = append(res, tdoc)
+	}
+	return res, err
+}
+
+func untypedOptions[T any](opts []TypedOption[T]) []OptionFunc {
+	res := make([]OptionFunc, 0, len(opts))
+	for _, opt := range opts {
+		res = append(res, OptionFunc(opt))
+	}
+	return res
+}
+
+// WithTypedLess 按照 less 函数对查询结果升序排序
+func WithTypedLess[T any](less func(a, b T) bool) TypedOption[T] {
+	return TypedOption[T](WithLess(func(a, b interface{}) bool {
+		return less(a.(T), b.(T))
+	}))
+}
+
+// WithTypedFilter 过滤查询结果，filter 返回 true 的文档会被过滤掉
+func WithTypedFilter[T any](filter func(a T) bool) TypedOption[T] {
+	return TypedOption[T](WithFilter(func(a interface{}) bool {
+		return filter(a.(T))
+	}))
+}
+
+// TypedOptions lifts the options that don't depend on the doc type, such as WithOrderBy, WithFrom and WithSize:
+//
+//	idx.Query(`Age >= 22`, index.TypedOptions[Cfg](index.WithFrom(10), index.WithSize(10))...)
+func TypedOptions[T any](opts ...OptionFunc) []TypedOption[T] {
+	res := make([]TypedOption[T], 0, len(opts))
+	for _, opt := range opts {
+		res = append(res, TypedOption[T](opt))
+	}
+	return res
+}
@@ -0,0 +1,97 @@
+package index_test
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+	"github.com/chirlchen/pans/index"
+)
+
+func TestTypedIndex_Query(t *testing.T) {
+	grow := func(in Cfg) Cfg {
+		in.Height++
+		return in
+	}
+	idx, err := index.NewTypedIndex(keys, []Cfg{d1, d2, d3, d4, d5, d6, d7}, grow)
+	if err != nil {
+		t.Fatalf("index.NewTypedIndex() error = %v", err)
+	}
+	h := func(cfgs ...Cfg) []int {
+		res := make([]int, 0, len(cfgs))
+		for _, cfg := range cfgs {
+			res = append(res, cfg.Height)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		opts    []index.TypedOption[Cfg]
+		want    []int
+		wantErr error
+	}{
+		{name: "query", query: `Age == 22`, want: h(d3, d4)},
+		{
+			name:  "less",
+			query: `Age >= 25`,
+			opts:  []index.TypedOption[Cfg]{index.WithTypedLess(func(a, b Cfg) bool { return a.Height < b.Height })},
+			want:  h(d5, d7, d6),
+		},
+		{
+			name:  "filter",
+			query: `Age >= 25`,
+			opts:  []index.TypedOption[Cfg]{index.WithTypedFilter(func(a Cfg) bool { return a.Name.First == "lucky" })},
+			want:  h(d5, d6),
+		},
+		{
+			name:  "untyped-options",
+			query: `Age >= 22`,
+			opts:  index.TypedOptions[Cfg](index.WithOrderBy(&index.OrderBy{FieldName: "Height", Ascend: true}), index.WithSize(2)),
+			want:  h(d5, d3),
+		},
+		{
+			name:    "eof",
+			query:   `Age >= 22`,
+			opts:    index.TypedOptions[Cfg](index.WithFrom(10), index.WithSize(2)),
+			want:    []int{},
+			wantErr: index.ErrEOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := idx.Query(tt.query, tt.opts...)
+			if err != tt.wantErr {
+				t.Fatalf("TypedIndex.Query() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			want := make([]int, 0, len(tt.want))
+			for _, height := range tt.want {
+				want = append(want, height+1)
+			}
+			assert.Equalf(t, want, h(got...), "TypedIndex.Query() got: %v", got)
+		})
+	}
+
+	d8 := Cfg{8, 22, 160, &Name{"new", "doc", nil}, nil, nil, nil, nil}
+	if err := idx.Upsert("8", d8); err != nil {
+		t.Fatalf("TypedIndex.Upsert() error = %v", err)
+	}
+	if err := idx.Delete("3"); err != nil {
+		t.Fatalf("TypedIndex.Delete() error = %v", err)
+	}
+	got, err := idx.Query(`Age == 22`)
+	if err != nil {
+		t.Fatalf("TypedIndex.Query() error = %v", err)
+	}
+	assert.Equalf(t, []int{179, 161}, h(got...), "TypedIndex.Query() after upsert and delete got: %v", got)
+
+	pq, err := idx.Prepare(`Age == ?`)
+	if err != nil {
+		t.Fatalf("TypedIndex.Prepare() error = %v", err)
+	}
+	got, err = pq.QueryWithParams(index.Args(22))
+	if err != nil {
+		t.Fatalf("TypedPreparedQuery.QueryWithParams() error = %v", err)
+	}
+	assert.Equalf(t, []int{179, 161}, h(got...), "TypedPreparedQuery.QueryWithParams() got: %v", got)
+}
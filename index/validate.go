@@ -0,0 +1,380 @@
+package index
+
+import (
+	"fmt"
+	"go/ast"
+	"go/scanner"
+	"go/token"
+	"go/types"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/araddon/qlbridge/value"
+	"github.com/blevesearch/vellum/regexp"
+)
+
+// 查询校验：查询在执行前按照索引的 Mapping 整体校验一次，而不是在执行到某个分支时才报错。校验内容包括：
+//   - 字段是否建立了索引
+//   - 操作符是否适用于字段类型，如 bool 字段不支持 `>`，文本字段不支持范围比较
+//   - 比较值的类型是否与字段类型一致，如 `Age == "12"`
+//   - 内置函数的参数个数以及参数类型，正则表达式是否合法
+//
+// 校验错误为 *QueryError，包含出错部分在查询中的位置，可以用于在界面上标出出错的部分。
+
+// QueryError is an error located in the query text, so that the faulty part of a query can be underlined
+type QueryError struct {
+	Offset int // byte offset of the faulty part in the query
+	End    int // byte offset right after the faulty part
+	Line   int // line of the faulty part, starting at 1
+	Column int // column of the faulty part in bytes, starting at 1
+	Msg    string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Validate 按照索引的 Mapping 校验查询，错误为 *QueryError
+func (i *Index) Validate(query string) error {
+	_, err := i.parse(query)
+	return err
+}
+
+// parse parses and validates a query against the mapping of the index
+func (i *Index) parse(query string) (*parsedQuery, error) {
+	pq, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if err := pq.validate(i.mapping); err != nil {
+		return nil, err
+	}
+	return pq, nil
+}
+
+// parseError converts the first error of the go parser to a QueryError
+func parseError(err error, src []byte) error {
+	list, ok := err.(scanner.ErrorList)
+	if !ok || len(list) == 0 {
+		return err
+	}
+
+	pos := list[0].Pos
+	return &QueryError{
+		Offset: pos.Offset,
+		End:    min(pos.Offset+1, len(src)),
+		Line:   pos.Line,
+		Column: pos.Column,
+		Msg:    list[0].Msg,
+	}
+}
+
+// fieldKind is the kind of values of a field, as far as the operators and the functions are concerned
+type fieldKind int
+
+const (
+	kindUnknown fieldKind = iota // not known from the first document, anything goes
+	kindString
+	kindText
+	kindNumber
+	kindTime
+	kindBool
+)
+
+var fieldKindNames = map[fieldKind]string{
+	kindString: "string",
+	kindText:   "text",
+	kindNumber: "number",
+	kindTime:   "time",
+	kindBool:   "bool",
+}
+
+func (k fieldKind) String() string {
+	return fieldKindNames[k]
+}
+
+func (fm *FieldMapping) kind() fieldKind {
+	if fm.Type == IndexTypeText {
+		return kindText
+	}
+	switch fm.valueType {
+	case value.StringType, value.StringsType:
+		return kindString
+	case value.IntType, value.NumberType, UintType, IntSliceType, FloatSliceType, UintSliceType:
+		return kindNumber
+	case value.TimeType:
+		return kindTime
+	case value.BoolType:
+		return kindBool
+	}
+	return kindUnknown
+}
+
+// literalKind is the kind of fields a literal can be compared with
+func literalKind(lit value.Value) fieldKind {
+	switch lit.(type) {
+	case value.StringValue:
+		return kindString
+	case value.IntValue, value.NumberValue, UintValue, DurationValue:
+		return kindNumber
+	case value.TimeValue:
+		return kindTime
+	case value.BoolValue:
+		return kindBool
+	}
+	return kindUnknown
+}
+
+// canCompare reports whether a literal of kind lit can be compared with a field of kind field, times are indexed as
+// unix nano timestamps so they can be compared with numbers too
+func canCompare(field, lit fieldKind) bool {
+	switch field {
+	case kindUnknown:
+		return true
+	case kindText:
+		return lit == kindString
+	case kindTime:
+		return lit == kindTime || lit == kindNumber
+	}
+	return field == lit
+}
+
+// funcSpec is the signature of a built-in function, its first argument is a field of one of the kinds
+type funcSpec struct {
+	minArgs, maxArgs int
+	kinds            []fieldKind
+}
+
+var builtinFuncSpecs = map[string]funcSpec{
+	"in_array": {2, 2, []fieldKind{kindString, kindText, kindNumber, kindTime, kindBool}},
+	"like":     {2, 2, []fieldKind{kindString, kindText}},
+	"ilike":    {2, 2, []fieldKind{kindString, kindText}},
+	"iequals":  {2, 2, []fieldKind{kindString, kindText}},
+	"prefix":   {2, 2, []fieldKind{kindString, kindText}},
+	"wildcard": {2, 2, []fieldKind{kindString, kindText}},
+	"fuzzy":    {2, 3, []fieldKind{kindString, kindText}},
+	"between":  {3, 3, []fieldKind{kindString, kindNumber, kindTime}},
+	"match":    {2, 2, []fieldKind{kindText}},
+}
+
+type validator struct {
+	pq      *parsedQuery
+	mapping *Mapping
+	ec      *evalContext // to evaluate the literals
+}
+
+// validate checks the query against the mapping, the first error found is returned as a *QueryError
+func (pq *parsedQuery) validate(mp *Mapping) error {
+	v := &validator{pq: pq, mapping: mp, ec: newEvalContext(nil, roaring.New())}
+	return v.cond(pq.expr)
+}
+
+func (v *validator) errorf(node ast.Node, format string, args ...any) error {
+	return v.errorAt(node.Pos(), node.End(), format, args...)
+}
+
+func (v *validator) errorAt(pos, end token.Pos, format string, args ...any) error {
+	p := v.pq.fset.Position(pos)
+	return &QueryError{
+		Offset: p.Offset,
+		End:    v.pq.fset.Position(end).Offset,
+		Line:   p.Line,
+		Column: p.Column,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+// cond checks an expression that selects docs
+func (v *validator) cond(expr ast.Expr) error {
+	switch expr := expr.(type) {
+	case *ast.BinaryExpr:
+		switch expr.Op {
+		case token.LAND, token.LOR:
+			if err := v.cond(expr.X); err != nil {
+				return err
+			}
+			return v.cond(expr.Y)
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GEQ, token.GTR:
+			return v.compare(expr)
+		default:
+			return v.errorAt(expr.OpPos, expr.OpPos+token.Pos(len(expr.Op.String())),
+				"operator %s is not supported, expected a comparison, && or ||", expr.Op)
+		}
+	case *ast.Ident, *ast.SelectorExpr: // bool field as a condition
+		field, fm, err := v.field(expr)
+		if err != nil {
+			return err
+		}
+		if kind := fm.kind(); kind != kindBool && kind != kindUnknown {
+			return v.errorf(expr, "%s field %s is not a condition, compare it with a value", kind, field)
+		}
+		return nil
+	case *ast.CallExpr:
+		return v.call(expr)
+	case *ast.ParenExpr:
+		return v.cond(expr.X)
+	case *ast.UnaryExpr:
+		if expr.Op != token.NOT {
+			return v.errorAt(expr.OpPos, expr.OpPos+token.Pos(len(expr.Op.String())), "operator %s is not supported on conditions", expr.Op)
+		}
+		return v.cond(expr.X)
+	}
+	return v.errorf(expr, "%s is not a condition", types.ExprString(expr))
+}
+
+// field checks that the expression is an indexed field
+func (v *validator) field(expr ast.Expr) (string, *FieldMapping, error) {
+	if ident, ok := expr.(*ast.Ident); ok {
+		if name, ok := v.pq.placeholders[ident.Pos()]; ok {
+			return "", nil, v.errorf(expr, "placeholder %s can only be a value, not a field", placeholderName(name))
+		}
+	}
+	field, err := parseIdent(expr)
+	if err != nil {
+		return "", nil, v.errorf(expr, "%s is not a field", types.ExprString(expr))
+	}
+	fm, ok := v.mapping.Field(field)
+	if !ok {
+		return "", nil, v.errorf(expr, "field %s is not indexed", field)
+	}
+	return field, fm, nil
+}
+
+func (v *validator) compare(expr *ast.BinaryExpr) error {
+	field, fm, err := v.field(expr.X)
+	if err != nil {
+		return err
+	}
+
+	kind := fm.kind()
+	if expr.Op != token.EQL && expr.Op != token.NEQ && (kind == kindBool || kind == kindText) {
+		return v.errorAt(expr.OpPos, expr.OpPos+token.Pos(len(expr.Op.String())),
+			"operator %s is not supported by the %s field %s", expr.Op, kind, field)
+	}
+	return v.value(expr.Y, field, kind)
+}
+
+// value checks that the literal can be compared with the field
+func (v *validator) value(expr ast.Expr, field string, kind fieldKind) error {
+	lit, known, err := v.literal(expr)
+	if err != nil || !known {
+		return err
+	}
+	if litKind := literalKind(lit); !canCompare(kind, litKind) {
+		return v.errorf(expr, "%s field %s can't be compared with %s", kind, field, types.ExprString(expr))
+	}
+	if t, ok := lit.(value.TimeValue); ok {
+		if _, err := unixNano(t.Val()); err != nil {
+			return v.errorf(expr, "%v", err)
+		}
+	}
+	return nil
+}
+
+// literal evaluates a literal, the value of a literal holding placeholders isn't known until the query is executed
+func (v *validator) literal(expr ast.Expr) (value.Value, bool, error) {
+	placeholder := false
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok {
+			_, placeholder = v.pq.placeholders[ident.Pos()]
+		}
+		return !placeholder
+	})
+	if placeholder {
+		return value.NilValueVal, false, nil
+	}
+
+	lit, err := parseBasicLit(expr, v.ec)
+	if err != nil {
+		return value.NilValueVal, false, v.errorf(expr, "%v", err)
+	}
+	return lit, true, nil
+}
+
+func (v *validator) call(expr *ast.CallExpr) error {
+	fn, ok := expr.Fun.(*ast.Ident)
+	if !ok {
+		return v.errorf(expr.Fun, "%s is not a function", types.ExprString(expr.Fun))
+	}
+	spec, ok := builtinFuncSpecs[fn.Name]
+	if !ok {
+		if _, ok := funcNameMap[fn.Name]; ok {
+			return nil // the arguments of the user functions are theirs to check
+		}
+		return v.errorf(fn, "unknown function %s", fn.Name)
+	}
+
+	if n := len(expr.Args); n < spec.minArgs || n > spec.maxArgs {
+		want := fmt.Sprint(spec.minArgs)
+		if spec.maxArgs != spec.minArgs {
+			want = fmt.Sprintf("%d to %d", spec.minArgs, spec.maxArgs)
+		}
+		return v.errorf(expr, "func %s: expected %s arguments, got %d", fn.Name, want, n)
+	}
+
+	field, fm, err := v.field(expr.Args[0])
+	if err != nil {
+		return err
+	}
+	kind := fm.kind()
+	supported := kind == kindUnknown
+	for _, k := range spec.kinds {
+		supported = supported || k == kind
+	}
+	if !supported {
+		return v.errorf(expr.Args[0], "func %s doesn't support the %s field %s", fn.Name, kind, field)
+	}
+
+	switch fn.Name {
+	case "in_array":
+		return v.inArray(expr.Args[1], field, kind)
+	case "between":
+		if err := v.value(expr.Args[1], field, kind); err != nil {
+			return err
+		}
+		return v.value(expr.Args[2], field, kind)
+	}
+
+	// the other functions take a string
+	lit, known, err := v.literal(expr.Args[1])
+	if err != nil {
+		return err
+	}
+	if known {
+		if _, ok := lit.(value.StringValue); !ok {
+			return v.errorf(expr.Args[1], "func %s: expected a string, got %s", fn.Name, types.ExprString(expr.Args[1]))
+		}
+		if fn.Name == "like" || fn.Name == "ilike" {
+			if _, err := regexp.New(lit.ToString()); err != nil {
+				return v.errorf(expr.Args[1], "func %s: %v", fn.Name, err)
+			}
+		}
+	}
+	if fn.Name == "fuzzy" && len(expr.Args) == 3 {
+		lit, known, err := v.literal(expr.Args[2])
+		if err != nil {
+			return err
+		}
+		if dist, ok := lit.(value.IntValue); known && (!ok || dist.Val() < 0 || dist.Val() > MaxFuzziness) {
+			return v.errorf(expr.Args[2], "func fuzzy: fuzziness must be an integer between 0 and %d", MaxFuzziness)
+		}
+	}
+	return nil
+}
+
+func (v *validator) inArray(expr ast.Expr, field string, kind fieldKind) error {
+	if ident, ok := expr.(*ast.Ident); ok {
+		if _, ok := v.pq.placeholders[ident.Pos()]; ok {
+			return nil
+		}
+	}
+	elts, ok := expr.(*ast.CompositeLit)
+	if !ok {
+		return v.errorf(expr, "func in_array: expected a slice literal such as []int{1, 2}, got %s", types.ExprString(expr))
+	}
+	for _, elt := range elts.Elts {
+		if err := v.value(elt, field, kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}